@@ -0,0 +1,97 @@
+package app
+
+import (
+	"container/list"
+	"slices"
+	"sync"
+)
+
+// defaultItemCacheSize is the default number of items kept by the cache in front of Select.
+const defaultItemCacheSize = 1000
+
+// itemCache is a fixed-size LRU cache of items by id.
+// It holds copies of the items, so callers may modify what they get from it.
+type itemCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // front is the most recently used; values are *Item
+	entries map[int]*list.Element
+	// gen is incremented on every invalidation so that an item read before a write is not cached after it.
+	gen uint64
+}
+
+func newItemCache(size int) *itemCache {
+	return &itemCache{
+		size:    size,
+		order:   list.New(),
+		entries: map[int]*list.Element{},
+	}
+}
+
+// get returns a copy of the cached item with id, if any.
+func (c *itemCache) get(id int) (*Item, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return copyItem(elem.Value.(*Item)), true
+}
+
+// generation returns the current generation, to be passed to add.
+func (c *itemCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gen
+}
+
+// add caches a copy of item, unless the cache has been invalidated since gen was taken.
+func (c *itemCache) add(item *Item, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	if elem, ok := c.entries[item.ID]; ok {
+		elem.Value = copyItem(item)
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[item.ID] = c.order.PushFront(copyItem(item))
+	//上限を超えたら最も古くに使われたitemを捨てる
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*Item).ID)
+	}
+}
+
+// invalidate removes the item with id from the cache.
+func (c *itemCache) invalidate(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if elem, ok := c.entries[id]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, id)
+	}
+}
+
+// copyItem returns a deep copy of item, so that the slices and DeletedAt are not shared.
+func copyItem(item *Item) *Item {
+	cp := *item
+	cp.Tags = slices.Clone(item.Tags)
+	cp.ImageNames = slices.Clone(item.ImageNames)
+	if item.DeletedAt != nil {
+		deletedAt := *item.DeletedAt
+		cp.DeletedAt = &deletedAt
+	}
+	return &cp
+}
@@ -0,0 +1,14 @@
+//go:build linux || darwin
+
+package app
+
+import "syscall"
+
+// freeDiskSpace returns the number of bytes available to the server on the file system containing path.
+func freeDiskSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
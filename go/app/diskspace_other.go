@@ -0,0 +1,10 @@
+//go:build !(linux || darwin)
+
+package app
+
+import "errors"
+
+// freeDiskSpace is not supported on this platform, so the disk space is not checked by GET /healthz .
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
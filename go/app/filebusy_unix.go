@@ -0,0 +1,18 @@
+//go:build unix
+
+package app
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isFileBusy reports whether err is a transient failure to write the file, which goes away by itself.
+// Open files don't block renaming them here, but the file system can still report the file or a lock as busy.
+func isFileBusy(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	return errno == syscall.EBUSY || errno == syscall.ETXTBSY || errno == syscall.EAGAIN
+}
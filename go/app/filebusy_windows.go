@@ -0,0 +1,22 @@
+package app
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Windows error codes returned while another process, such as an antivirus scanner, has the file open.
+const (
+	errorAccessDenied     syscall.Errno = 5
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// isFileBusy reports whether err is because another process has the file open, which goes away by itself.
+func isFileBusy(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	return errno == errorAccessDenied || errno == errorSharingViolation || errno == errorLockViolation
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gcImagesMinAge is how old an unreferenced image must be to be removed.
+// Images are stored before their item is inserted, so newer ones may be of an upload in progress.
+const gcImagesMinAge = time.Hour
+
+// GCImagesResult summarises a collection of orphaned images.
+type GCImagesResult struct {
+	// Removed are the names of the unreferenced files, which were removed unless it was a dry run.
+	Removed []string
+	// Bytes is the total size of Removed.
+	Bytes int64
+}
+
+// CollectOrphanedImages removes the files in imgDirPath which are not the image of any item in repo,
+// including deleted items since they can be restored. Thumbnails of referenced images, defaultImage,
+// dotfiles and files modified within gcImagesMinAge of now are kept. If dryRun is true, nothing is removed.
+func CollectOrphanedImages(ctx context.Context, imgDirPath, defaultImage string, repo ItemRepository, now time.Time, dryRun bool) (GCImagesResult, error) {
+	items, err := repo.ListAll(ctx)
+	if err != nil {
+		return GCImagesResult{}, fmt.Errorf("failed to list items: %w", err)
+	}
+	referenced := map[string]bool{}
+	for _, name := range append([]string{defaultImage}, referencedImageNames(items)...) {
+		referenced[name] = true
+		//サムネイルも元の画像と一緒に残す
+		for _, ext := range []string{".jpg", ".webp"} {
+			referenced[filepath.Base(thumbnailPath(name, ext))] = true
+		}
+	}
+
+	entries, err := os.ReadDir(imgDirPath)
+	if err != nil {
+		return GCImagesResult{}, fmt.Errorf("failed to read image directory: %w", err)
+	}
+
+	var result GCImagesResult
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") || referenced[name] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return result, fmt.Errorf("failed to stat %s: %w", name, err)
+		}
+		if now.Sub(info.ModTime()) < gcImagesMinAge {
+			slog.DebugContext(ctx, "skipped recent unreferenced image", "name", name)
+			continue
+		}
+
+		if !dryRun {
+			if err := os.Remove(filepath.Join(imgDirPath, name)); err != nil && !os.IsNotExist(err) {
+				return result, fmt.Errorf("failed to remove %s: %w", name, err)
+			}
+		}
+		result.Removed = append(result.Removed, name)
+		result.Bytes += info.Size()
+	}
+
+	return result, nil
+}
+
+// referencedImageNames returns the names of all the images of items.
+func referencedImageNames(items []*Item) []string {
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		names = append(names, item.allImageNames()...)
+	}
+	return names
+}
+
+// GCImages is the gc-images subcommand. It removes the images in imgDirPath which no item
+// in the repository configured by the environment refers to (see CollectOrphanedImages).
+// With --dry-run, it only prints the files which would be removed.
+func GCImages(imgDirPath string, args []string) int {
+	fs := flag.NewFlagSet("gc-images", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "print the unreferenced images without removing them")
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: gc-images [--dry-run]")
+		return 2
+	}
+
+	itemRepo, err := NewItemRepositoryFromEnv()
+	if err != nil {
+		slog.Error("failed to set up item repository: ", "error", err)
+		return 1
+	}
+
+	defaultImage, found := os.LookupEnv("DEFAULT_IMAGE")
+	if !found {
+		defaultImage = defaultImageName
+	}
+
+	result, err := CollectOrphanedImages(context.Background(), imgDirPath, defaultImage, itemRepo, time.Now(), *dryRun)
+	for _, name := range result.Removed {
+		fmt.Println(name)
+	}
+	verb := "removed"
+	if *dryRun {
+		verb = "would remove"
+	}
+	fmt.Printf("%s %d images (%d bytes)\n", verb, len(result.Removed), result.Bytes)
+	if err != nil {
+		slog.Error("failed to collect orphaned images: ", "error", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCollectOrphanedImages(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-2 * gcImagesMinAge)
+
+	type file struct {
+		name    string
+		modTime time.Time
+	}
+	files := []file{
+		{name: "default.jpg", modTime: old},
+		{name: ".gitignore", modTime: old},
+		{name: "jacket.jpg", modTime: old},
+		{name: "jacket.thumb.jpg", modTime: old},
+		{name: "jacket.thumb.webp", modTime: old},
+		{name: "deleted.png", modTime: old},
+		{name: "second.png", modTime: old},
+		{name: "orphan.jpg", modTime: old},
+		{name: "orphan.thumb.jpg", modTime: old},
+		{name: "upload-123.tmp", modTime: old},
+		{name: "uploading.jpg", modTime: now.Add(-time.Minute)},
+	}
+
+	cases := map[string]struct {
+		dryRun bool
+	}{
+		"ok: orphaned images are removed": {
+			dryRun: false,
+		},
+		"ok: dry run removes nothing": {
+			dryRun: true,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			for _, f := range files {
+				path := filepath.Join(dir, f.name)
+				if err := os.WriteFile(path, []byte("image"), 0644); err != nil {
+					t.Fatalf("failed to write %s: %v", f.name, err)
+				}
+				if err := os.Chtimes(path, f.modTime, f.modTime); err != nil {
+					t.Fatalf("failed to set the time of %s: %v", f.name, err)
+				}
+			}
+			if err := os.Mkdir(filepath.Join(dir, "subdir"), 0755); err != nil {
+				t.Fatalf("failed to create subdir: %v", err)
+			}
+
+			ctx := context.Background()
+			repo := NewMemoryItemRepository()
+			if _, err := repo.Insert(ctx, &Item{Name: "jacket", Category: "fashion", ImageName: "jacket.jpg", ImageNames: []string{"jacket.jpg", "second.png"}}); err != nil {
+				t.Fatalf("failed to insert item: %v", err)
+			}
+			id, err := repo.Insert(ctx, &Item{Name: "shoes", Category: "fashion", ImageName: "deleted.png"})
+			if err != nil {
+				t.Fatalf("failed to insert item: %v", err)
+			}
+			if err := repo.Delete(ctx, id); err != nil {
+				t.Fatalf("failed to delete item: %v", err)
+			}
+			if _, err := repo.Insert(ctx, &Item{Name: "no image", Category: "fashion"}); err != nil {
+				t.Fatalf("failed to insert item: %v", err)
+			}
+
+			result, err := CollectOrphanedImages(ctx, dir, "default.jpg", repo, now, tt.dryRun)
+			if err != nil {
+				t.Fatalf("failed to collect orphaned images: %v", err)
+			}
+			orphans := []string{"orphan.jpg", "orphan.thumb.jpg", "upload-123.tmp"}
+			if diff := cmp.Diff(orphans, result.Removed); diff != "" {
+				t.Errorf("unexpected removed images (-want +got):\n%s", diff)
+			}
+			if want := int64(len(orphans) * len("image")); result.Bytes != want {
+				t.Errorf("expected %d bytes, got %d", want, result.Bytes)
+			}
+
+			for _, f := range files {
+				_, err := os.Stat(filepath.Join(dir, f.name))
+				if removed := !tt.dryRun && slices.Contains(orphans, f.name); removed != os.IsNotExist(err) {
+					t.Errorf("expected %s removed: %v, got error %v", f.name, removed, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var errIdempotencyKeyInUse = errors.New("idempotency key in use")
+
+// idempotencyKeyTTL is how long a response is kept for an Idempotency-Key.
+const idempotencyKeyTTL = 24 * time.Hour
+
+// maxIdempotencyKeyLength is the maximum length of an Idempotency-Key header.
+const maxIdempotencyKeyLength = 255
+
+// idempotencyStore remembers the responses of POST /items by Idempotency-Key
+// so that a retried request returns the original response instead of adding the item again.
+// Keys are kept in memory only and are scoped to POST /items and to the seller (AddItem prefixes them with the seller id).
+type idempotencyStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*idempotencyEntry
+	// now returns the current time. It is replaced in tests.
+	now func() time.Time
+}
+
+type idempotencyEntry struct {
+	// resp is nil while the request with the key is in progress.
+	resp      *AddItemResponse
+	expiresAt time.Time
+}
+
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	return &idempotencyStore{
+		ttl:     ttl,
+		entries: map[string]*idempotencyEntry{},
+		now:     time.Now,
+	}
+}
+
+// reserve returns the stored response for key if there is one.
+// Otherwise it marks key as in progress and returns nil, and the caller must call complete or release.
+// If another request with key is in progress, it returns errIdempotencyKeyInUse.
+func (s *idempotencyStore) reserve(key string) (*AddItemResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	//期限切れのキーを削除する
+	for k, e := range s.entries {
+		if now.After(e.expiresAt) {
+			delete(s.entries, k)
+		}
+	}
+
+	if e, ok := s.entries[key]; ok {
+		if e.resp == nil {
+			return nil, errIdempotencyKeyInUse
+		}
+		return e.resp, nil
+	}
+
+	s.entries[key] = &idempotencyEntry{expiresAt: now.Add(s.ttl)}
+	return nil, nil
+}
+
+// complete stores resp as the response for key.
+func (s *idempotencyStore) complete(key string, resp *AddItemResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = &idempotencyEntry{resp: resp, expiresAt: s.now().Add(s.ttl)}
+}
+
+// release forgets key so that the request can be retried with it.
+func (s *idempotencyStore) release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+}
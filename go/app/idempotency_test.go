@@ -0,0 +1,37 @@
+package app
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	store := newIdempotencyStore(time.Hour)
+	store.now = func() time.Time { return now }
+
+	if resp, err := store.reserve("key"); resp != nil || err != nil {
+		t.Fatalf("expected a new key, got %v, %v", resp, err)
+	}
+	if _, err := store.reserve("key"); !errors.Is(err, errIdempotencyKeyInUse) {
+		t.Errorf("expected errIdempotencyKeyInUse, got %v", err)
+	}
+
+	store.complete("key", &AddItemResponse{ID: 1})
+	resp, err := store.reserve("key")
+	if err != nil {
+		t.Fatalf("failed to reserve key: %v", err)
+	}
+	if resp == nil || resp.ID != 1 {
+		t.Errorf("expected the stored response, got %v", resp)
+	}
+
+	// after the ttl, the key can be used again
+	now = now.Add(time.Hour + time.Second)
+	if resp, err := store.reserve("key"); resp != nil || err != nil {
+		t.Errorf("expected an expired key, got %v, %v", resp, err)
+	}
+}
@@ -0,0 +1,236 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+	_ "golang.org/x/image/webp" // register the WebP decoder for image.Decode
+)
+
+// defaultMaxImageSize is the default maximum width and height of stored images in pixels.
+const defaultMaxImageSize = 1024
+
+// thumbnailMaxSize is the maximum width and height of thumbnails in pixels.
+const thumbnailMaxSize = 200
+
+// resizeImage downscales img to fit within maxSize x maxSize, keeping its aspect ratio.
+// Images which already fit are returned as is.
+func resizeImage(img image.Image, maxSize int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxSize && h <= maxSize {
+		return img
+	}
+
+	//長い辺をmaxSizeに合わせる
+	nw, nh := maxSize, maxSize
+	if w > h {
+		nh = max(h*maxSize/w, 1)
+	} else {
+		nw = max(w*maxSize/h, 1)
+	}
+
+	// nearest neighbor sampling is good enough for small previews
+	dst := image.NewRGBA(image.Rect(0, 0, nw, nh))
+	for y := 0; y < nh; y++ {
+		sy := b.Min.Y + y*h/nh
+		for x := 0; x < nw; x++ {
+			sx := b.Min.X + x*w/nw
+			dst.Set(x, y, img.At(sx, sy))
+		}
+	}
+
+	return dst
+}
+
+// thumbnailPath returns the path of the cached thumbnail for the image at imgPath.
+// ext is the extension of the thumbnail's format, ".jpg" or ".webp".
+func thumbnailPath(imgPath, ext string) string {
+	return strings.TrimSuffix(imgPath, filepath.Ext(imgPath)) + ".thumb" + ext
+}
+
+// isThumbnail reports whether the image file name is of a cached thumbnail (see thumbnailPath).
+func isThumbnail(name string) bool {
+	return strings.Contains(name, ".thumb.")
+}
+
+// ensureThumbnail creates the thumbnail of the image at srcPath at dstPath unless it is already cached.
+func ensureThumbnail(srcPath, dstPath string) error {
+	if _, err := os.Stat(dstPath); err == nil {
+		return nil
+	}
+	return createThumbnail(srcPath, dstPath)
+}
+
+// createThumbnail writes a thumbnail of the image at srcPath to dstPath.
+// It is encoded in WebP if dstPath ends with .webp, and in JPEG otherwise.
+// The WebP encoder is lossless, so WebP thumbnails of photos can be larger than JPEG ones.
+func createThumbnail(srcPath, dstPath string) error {
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open image: %w", err)
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	buf := &bytes.Buffer{}
+	thumb := resizeImage(img, thumbnailMaxSize)
+	if filepath.Ext(dstPath) == ".webp" {
+		err = nativewebp.Encode(buf, thumb, nil)
+	} else {
+		err = jpeg.Encode(buf, thumb, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+
+	return StoreImage(dstPath, buf.Bytes())
+}
+
+// storedJPEGQuality is the quality of JPEG images re-encoded for storage.
+const storedJPEGQuality = 90
+
+// jpegHeaderSize is how much of the beginning of a JPEG file is read to find its EXIF orientation.
+// The EXIF segment comes right after the start of the file and is at most 64KB.
+const jpegHeaderSize = 128 << 10
+
+// writeCleanImage decodes the image read from r, rotates it as its EXIF orientation says, downscales it to fit
+// within maxSize x maxSize and writes it to w re-encoded in the same format. Re-encoding drops
+// metadata such as EXIF, which may contain the GPS location where a photo was taken. Images which
+// cannot be decoded or have no encoder in the standard library (WebP) are written as is.
+func writeCleanImage(w io.Writer, r io.ReadSeeker, maxSize int) error {
+	img, format, err := image.Decode(r)
+	//デコードした後は先頭から読み直す
+	if _, seekErr := r.Seek(0, io.SeekStart); seekErr != nil {
+		return seekErr
+	}
+	if err != nil || (format != "jpeg" && format != "png") {
+		_, err := io.Copy(w, r)
+		return err
+	}
+	//EXIFを取り除く前に、EXIFの向きの情報どおりに画素を回転させておく
+	if format == "jpeg" {
+		head, err := io.ReadAll(io.LimitReader(r, jpegHeaderSize))
+		if err != nil {
+			return err
+		}
+		img = orientImage(img, jpegOrientation(head))
+	}
+	resized := resizeImage(img, maxSize)
+
+	if format == "png" {
+		err = png.Encode(w, resized)
+	} else {
+		err = jpeg.Encode(w, resized, &jpeg.Options{Quality: storedJPEGQuality})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	return nil
+}
+
+// jpegOrientation returns the EXIF orientation tag of a JPEG image, or 1 (upright) if there is none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	//SOIの後のセグメントを順に見て、EXIFが入っているAPP1を探す
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			return 1
+		}
+		marker := data[pos+1]
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if marker == 0xDA || length < 2 || pos+2+length > len(data) { // image data starts at SOS
+			return 1
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		pos += 2 + length
+	}
+
+	return 1
+}
+
+// tiffOrientation returns the orientation tag in the first IFD of TIFF data, or 1 if there is none.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := range entries {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 { // Orientation, a SHORT
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+
+	return 1
+}
+
+// orientImage rotates img so that it is upright for the EXIF orientation.
+// Only rotations (3, 6 and 8) are handled; other values, including mirrored ones, leave img as is.
+func orientImage(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	var dst *image.RGBA
+	var at func(x, y int) (int, int) // maps a destination pixel to the source pixel
+	switch orientation {
+	case 3: // rotated 180°
+		dst = image.NewRGBA(image.Rect(0, 0, w, h))
+		at = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 6: // needs rotating 90° clockwise
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+		at = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 8: // needs rotating 90° counterclockwise
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+		at = func(x, y int) (int, int) { return w - 1 - y, x }
+	default:
+		return img
+	}
+
+	db := dst.Bounds()
+	for y := 0; y < db.Dy(); y++ {
+		for x := 0; x < db.Dx(); x++ {
+			sx, sy := at(x, y)
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+
+	return dst
+}
@@ -3,21 +3,140 @@ package app
 import (
 	"context"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"math/rand/v2"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 	// STEP 5-1: uncomment this line
 	// _ "github.com/mattn/go-sqlite3"
 )
 
 var errImageNotFound = errors.New("image not found")
 var errItemNotFound = errors.New("item not found")
+var errUnsupportedImageType = errors.New("unsupported image type")
+var errImageTooLarge = errors.New("image too large")
+var errBodyTooLarge = errors.New("request body too large")
+var errDuplicateItem = errors.New("duplicate item")
+var errOutOfStock = errors.New("out of stock")
+var errVersionConflict = errors.New("version conflict")
+var errRepositoryBusy = errors.New("repository busy")
+
+// batchError reports which item of a batch couldn't be stored.
+type batchError struct {
+	Index int
+	ID    int // the existing item's ID if Err is errDuplicateItem
+	Err   error
+}
+
+func (e *batchError) Error() string {
+	return fmt.Sprintf("item %d: %v", e.Index, e.Err)
+}
+
+func (e *batchError) Unwrap() error {
+	return e.Err
+}
+
+// duplicateItemError reports the existing item which an update would duplicate.
+type duplicateItemError struct {
+	ID int // the existing item's ID
+}
+
+func (e *duplicateItemError) Error() string {
+	return fmt.Sprintf("%v: item %d", errDuplicateItem, e.ID)
+}
+
+func (e *duplicateItemError) Unwrap() error {
+	return errDuplicateItem
+}
 
 type Item struct {
-	ID        int    `db:"id" json:"-"`
+	ID          int        `db:"id" json:"id" xml:"id"`
+	Name        string     `db:"name" json:"name" xml:"name"`
+	Category    string     `db:"category" json:"category" xml:"category"`
+	ImageName   string     `db:"image" json:"image" xml:"image"`
+	Price       int        `db:"price" json:"price" xml:"price"` // in yen
+	Stock       int        `db:"stock" json:"stock" xml:"stock"`
+	Description string     `db:"description" json:"description" xml:"description"`
+	Tags        []string   `db:"-" json:"tags,omitempty" xml:"tags>tag"`
+	ImageNames  []string   `db:"-" json:"image_names,omitempty" xml:"image_names>image_name"`       // all the images, the first being ImageName
+	SellerID    string     `db:"seller_id" json:"seller_id,omitempty" xml:"seller_id,omitempty"`    // the sub claim of the seller's token
+	Version     int        `db:"version" json:"version" xml:"version"`                              // incremented on every change
+	DeletedAt   *time.Time `db:"deleted_at" json:"deleted_at,omitempty" xml:"deleted_at,omitempty"` // set when the item is deleted
+	CreatedAt   time.Time  `db:"created_at" json:"created_at" xml:"created_at"`
+	UpdatedAt   time.Time  `db:"updated_at" json:"updated_at" xml:"updated_at"`
+}
+
+// storedItem is an Item as saved in the JSON file, without the fields computed by Item.MarshalJSON.
+type storedItem Item
+
+// imageURL returns the path to the item's image, or "" if it has none.
+func (item Item) imageURL() string {
+	if item.ImageName == "" {
+		return ""
+	}
+	return "/images/" + item.ImageName
+}
+
+// allImageNames returns the names of all the item's images, the first being ImageName.
+// Items added before multiple images were supported only have ImageName.
+func (item Item) allImageNames() []string {
+	if len(item.ImageNames) > 0 {
+		return item.ImageNames
+	}
+	if item.ImageName == "" {
+		return nil
+	}
+	return []string{item.ImageName}
+}
+
+// imageURLs returns the paths to all the item's images.
+func (item Item) imageURLs() []string {
+	urls := []string{}
+	for _, name := range item.allImageNames() {
+		urls = append(urls, "/images/"+name)
+	}
+	return urls
+}
+
+// MarshalJSON adds image_url, the path to the item's image, and images, the paths to all its images,
+// to the JSON of the item, and always includes tags.
+func (item Item) MarshalJSON() ([]byte, error) {
+	imageURL := item.imageURL()
+
+	//タグがないときもnullではなく空の配列を返す
+	if item.Tags == nil {
+		item.Tags = []string{}
+	}
+
+	return json.Marshal(struct {
+		storedItem
+		Tags     []string `json:"tags"`
+		ImageURL string   `json:"image_url"`
+		Images   []string `json:"images"`
+	}{storedItem(item), item.Tags, imageURL, item.imageURLs()})
+}
+
+// MarshalXML encodes the item as an <item> element with image_url and images added, like MarshalJSON.
+func (item Item) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	//ルート要素のときも型名のItemではなくitemにする
+	start.Name = xml.Name{Local: "item"}
+	return e.EncodeElement(struct {
+		storedItem
+		ImageURL string   `xml:"image_url"`
+		Images   []string `xml:"images>image"`
+	}{storedItem(item), item.imageURL(), item.imageURLs()}, start)
+}
+
+type Category struct {
+	ID        int    `db:"id" json:"id"`
 	Name      string `db:"name" json:"name"`
-	Category  string `db:"category" json:"category"`
-	ImageName string `db:"image" json:"image"`
+	ItemCount int    `db:"item_count" json:"item_count"`
 }
 
 // Please run `go generate ./...` to generate the mock implementation
@@ -27,106 +146,905 @@ type Item struct {
 //
 //go:generate go run go.uber.org/mock/mockgen -source=$GOFILE -package=${GOPACKAGE} -destination=./mock_$GOFILE
 type ItemRepository interface {
-	Insert(ctx context.Context, item *Item) error
+	// Insert stores item and returns its ID.
+	// If an item with the same name and category exists, it returns the existing ID and errDuplicateItem.
+	Insert(ctx context.Context, item *Item) (int, error)
+	InsertBatch(ctx context.Context, items []*Item) ([]int, error)
 	List(ctx context.Context) ([]*Item, error)
+	// ListAll returns all the items including deleted ones, for administrators.
+	ListAll(ctx context.Context) ([]*Item, error)
 	Select(ctx context.Context, id int) (*Item, error)
+	// SelectRandom returns an item chosen at random, or errItemNotFound if there are no items.
+	SelectRandom(ctx context.Context) (*Item, error)
+	Search(ctx context.Context, keyword string, mode searchMode) ([]*Item, error)
+	SearchPage(ctx context.Context, keyword string, mode searchMode, limit, offset int) ([]*Item, int, error)
+	// Delete marks the item as deleted. Deleted items are hidden from the other methods until restored.
+	Delete(ctx context.Context, id int) error
+	// Restore undoes Delete and returns the item.
+	// It returns errDuplicateItem if an item with the same name and category has been added in the meantime.
+	Restore(ctx context.Context, id int) (*Item, error)
+	// Update updates the item with item.ID if its version is still item.Version.
+	// Otherwise it returns errVersionConflict, so that concurrent edits don't overwrite each other.
+	// A zero item.Version updates the item whatever its version is.
+	// If another item has the new name and category, it returns a *duplicateItemError with its ID.
+	Update(ctx context.Context, item *Item) error
+	ListCategories(ctx context.Context) ([]*Category, error)
+	// ReassignCategory moves all the items in the category from to the category into and returns how many were moved.
+	// Nothing is moved if an item in from has the same name as one in into; it returns errDuplicateItem instead.
+	ReassignCategory(ctx context.Context, from, into string) (int, error)
+	Count(ctx context.Context) (int, error)
+	ListByCategory(ctx context.Context, category string) ([]*Item, error)
+	// ListBySeller returns the items added by the seller with sellerID.
+	ListBySeller(ctx context.Context, sellerID string) ([]*Item, error)
+	// ListSimilar returns up to limit other items in the category of the item with id.
+	// It returns errItemNotFound if there is no such item.
+	ListSimilar(ctx context.Context, id, limit int) ([]*Item, error)
+	// Purchase decrements the stock of the item by 1 and returns the updated item.
+	// It returns errOutOfStock if the stock is already 0.
+	Purchase(ctx context.Context, id int) (*Item, error)
+	Suggest(ctx context.Context, prefix string, limit int) ([]string, error)
+	// MigrateSchema brings the stored data to the current layout. It is called on startup.
+	MigrateSchema(ctx context.Context) error
 }
 
 // itemRepository is an implementation of ItemRepository
 type itemRepository struct {
 	// fileName is the path to the JSON file storing items.
+	// If it is empty, the JSON data is kept in memory instead.
 	fileName string
+	// mu serializes writes. Each write reads the whole file, modifies it and writes it back,
+	// so concurrent writes would otherwise overwrite each other's changes.
+	// Reads need no lock because save replaces the file atomically.
+	mu sync.Mutex
+
+	// memData is the JSON data of the items when fileName is empty.
+	memData   []byte
+	memDataMu sync.RWMutex
+
+	// timeout bounds each repository call. If it is 0, defaultRepoTimeout is used.
+	timeout time.Duration
+
+	// cache keeps recently selected items so that Select of a hot item does not read the whole file.
+	// If it is nil, nothing is cached.
+	cache *itemCache
 }
 
-// NewItemRepository creates a new itemRepository.
-func NewItemRepository() ItemRepository {
-	return &itemRepository{fileName: "items.json"}
+// defaultRepoTimeout is the default time limit of a repository call.
+const defaultRepoTimeout = 3 * time.Second
+
+// withTimeout derives a context for a repository call which is cancelled after the repository timeout.
+// It returns an error right away if ctx is already done.
+func (i *itemRepository) withTimeout(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	timeout := i.timeout
+	if timeout <= 0 {
+		timeout = defaultRepoTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	if err := ctx.Err(); err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	return ctx, cancel, nil
 }
 
-// Insert inserts an item into the repository.
-func (i *itemRepository) Insert(ctx context.Context, item *Item) error {
-	// STEP 4-2: add an implementation to store an item
-	file, err := os.OpenFile(i.fileName, os.O_RDWR|os.O_CREATE, 0644)
+// NewItemRepository creates a new itemRepository storing items in the given JSON file.
+func NewItemRepository(fileName string) ItemRepository {
+	return &itemRepository{fileName: fileName}
+}
+
+// NewMemoryItemRepository creates a new itemRepository keeping items in memory only.
+// The items are lost when the process exits, which is handy for local development and tests.
+func NewMemoryItemRepository() ItemRepository {
+	return &itemRepository{}
+}
+
+// NewItemRepositoryFromEnv creates an ItemRepository chosen by the REPO_BACKEND environment variable:
+// "file" (default) stores items in the JSON file at DB_PATH (default: items.json),
+// and "memory" keeps them in memory only.
+// REPO_TIMEOUT sets the time limit of each repository call (default: 3s),
+// and ITEM_CACHE_SIZE the number of items cached for Select (default: 1000, 0 disables the cache).
+func NewItemRepositoryFromEnv() (ItemRepository, error) {
+	timeout, err := lookupEnvDuration("REPO_TIMEOUT", defaultRepoTimeout)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	cacheSize, err := lookupEnvNonNegativeInt("ITEM_CACHE_SIZE", defaultItemCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	var cache *itemCache
+	if cacheSize > 0 {
+		cache = newItemCache(cacheSize)
+	}
+
+	backend, found := os.LookupEnv("REPO_BACKEND")
+	if !found {
+		backend = "file"
+	}
+
+	switch backend {
+	case "file":
+		dbPath, found := os.LookupEnv("DB_PATH")
+		if !found {
+			dbPath = "items.json"
+		}
+		return &itemRepository{fileName: dbPath, timeout: timeout, cache: cache}, nil
+	case "memory":
+		return &itemRepository{timeout: timeout, cache: cache}, nil
+	default:
+		return nil, fmt.Errorf("REPO_BACKEND must be file or memory: %q", backend)
 	}
-	defer file.Close()
+}
+
+// Insert inserts an item into the repository and returns its id.
+func (i *itemRepository) Insert(ctx context.Context, item *Item) (int, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
 
+	// STEP 4-2: add an implementation to store an item
 	// 既存データを読み込む
-	var data struct {
-		Items []Item `json:"items"`
+	items, err := i.loadItems(ctx)
+	if err != nil {
+		return 0, err
 	}
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&data); err != nil && err.Error() != "EOF" {
-		return err
+
+	items, err = appendItem(items, item, time.Now().UTC())
+	if err != nil {
+		return item.ID, err
 	}
 
-	// 新しい item を追加
-	data.Items = append(data.Items, *item)
+	if err := i.save(items); err != nil {
+		return 0, err
+	}
 
-	// ファイルをクリアして新しい JSON データを書き込む
-	file.Seek(0, 0)  // ファイルの先頭に戻る
-	file.Truncate(0) // ファイルを空にする
+	return item.ID, nil
+}
 
-	encoder := json.NewEncoder(file)
-	if err := encoder.Encode(data); err != nil {
-		return err
+// InsertBatch stores all the items at once and returns their IDs in order.
+// If any item can't be stored, nothing is stored and a *batchError tells which one failed.
+func (i *itemRepository) InsertBatch(ctx context.Context, batch []*Item) ([]int, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	items, err := i.loadItems(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	now := time.Now().UTC()
+	ids := make([]int, 0, len(batch))
+	for idx, item := range batch {
+		items, err = appendItem(items, item, now)
+		if err != nil {
+			return nil, &batchError{Index: idx, ID: item.ID, Err: err}
+		}
+		ids = append(ids, item.ID)
+	}
+
+	// 途中で失敗したときは何も保存しないように、最後にまとめて保存する
+	if err := i.save(items); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// appendItem assigns a new ID and timestamps to item and appends it to items.
+// If an item with the same name and category exists, it sets item.ID to the existing ID and returns errDuplicateItem.
+func appendItem(items []*Item, item *Item, now time.Time) ([]*Item, error) {
+	// 同じ name と category の item は登録しない(削除済みのitemは除く)
+	for _, it := range items {
+		if it.DeletedAt == nil && it.Name == item.Name && it.Category == item.Category {
+			item.ID = it.ID
+			return items, errDuplicateItem
+		}
+	}
+
+	// 新しい item のidは、既存のidの最大値+1
+	item.ID = 1
+	for _, it := range items {
+		if it.ID >= item.ID {
+			item.ID = it.ID + 1
+		}
+	}
+
+	// 作成日時と更新日時はサーバー側で設定する
+	item.CreatedAt = now
+	item.UpdatedAt = now
+	item.Version = 1
+
+	return append(items, item), nil
 }
 
 // List get all items
+// Deleted items are not included.
 func (i *itemRepository) List(ctx context.Context) ([]*Item, error) {
+	items, err := i.loadItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(items, func(item *Item) bool { return item.DeletedAt != nil }), nil
+}
+
+// ListAll get all items including deleted ones
+func (i *itemRepository) ListAll(ctx context.Context) ([]*Item, error) {
+	return i.loadItems(ctx)
+}
+
+// loadItems reads all the stored items including deleted ones.
+// Writes must use it instead of List, since they save the items back as a whole.
+func (i *itemRepository) loadItems(ctx context.Context) ([]*Item, error) {
+	ctx, cancel, err := i.withTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
 	//dataに、jsonに保存されている中のitem
 	var data struct {
-		Items []*Item `json:"items"`
+		Version int     `json:"version"`
+		Items   []*Item `json:"items"`
 	}
 
-	dataBytes, err := os.ReadFile(i.fileName)
+	dataBytes, err := i.load()
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	//json.Unmarshal->JSON のバイト列 (dataBytes) を Go の構造体 (data) に変換する関数
 	if err := json.Unmarshal(dataBytes, &data); err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
+	//古い形式のデータは、読み込むたびに今の形式に変換する(MigrateSchemaで保存し直せる)
+	if data.Version > len(itemMigrations) {
+		return nil, fmt.Errorf("unsupported schema version %d", data.Version)
+	}
+	for _, migrate := range itemMigrations[data.Version:] {
+		migrate(data.Items)
+	}
+
 	return data.Items, nil
+}
+
+// itemMigrations upgrade the items in the JSON file to the current layout.
+// itemMigrations[v] upgrades items saved with version v to version v+1,
+// and save writes the file with version len(itemMigrations). Append new migrations at the end.
+var itemMigrations = []func(items []*Item){
+	// 1: items have stable IDs. Older files identified items by their position.
+	func(items []*Item) {
+		for idx, item := range items {
+			if item.ID == 0 {
+				item.ID = idx + 1
+			}
+		}
+	},
+	// 2: items have a stock. Items listed before had one of each.
+	func(items []*Item) {
+		for _, item := range items {
+			item.Stock = defaultStock
+		}
+	},
+	// 3: items have a version for optimistic concurrency control.
+	func(items []*Item) {
+		for _, item := range items {
+			item.Version = 1
+		}
+	},
+}
+
+// defaultStock is the stock of an item listed without one.
+const defaultStock = 1
+
+// MigrateSchema rewrites the stored items in the current layout if they were saved in an older one.
+// A missing file is left as is, since it is created in the current layout on the first insert.
+func (i *itemRepository) MigrateSchema(ctx context.Context) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	dataBytes, err := i.load()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var data struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(dataBytes, &data); err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	if data.Version == len(itemMigrations) {
+		return nil
+	}
+
+	items, err := i.loadItems(ctx)
+	if err != nil {
+		return err
+	}
 
+	return i.save(items)
 }
 
 // Select select item from id
 func (i *itemRepository) Select(ctx context.Context, id int) (*Item, error) {
-	//場合分けしてあげる
-	//idが0以下はおかしいのでエラー
-	if id <= 0 {
+	var gen uint64
+	if i.cache != nil {
+		if item, ok := i.cache.get(id); ok {
+			return item, nil
+		}
+		gen = i.cache.generation()
+	}
+
+	items, err := i.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := findItem(items, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if i.cache != nil {
+		i.cache.add(items[idx], gen)
+	}
+
+	return items[idx], nil
+
+}
+
+// SelectRandom returns an item chosen at random.
+func (i *itemRepository) SelectRandom(ctx context.Context) (*Item, error) {
+	items, err := i.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) == 0 {
 		return nil, errItemNotFound
 	}
 
+	return items[rand.IntN(len(items))], nil
+}
+
+// invalidateCache removes the item with id from the Select cache. It is called after the item is changed.
+func (i *itemRepository) invalidateCache(id int) {
+	if i.cache != nil {
+		i.cache.invalidate(id)
+	}
+}
+
+// findItem returns the index of the item with the given id. Deleted items are not found.
+func findItem(items []*Item, id int) (int, error) {
+	for idx, item := range items {
+		if item.ID == id && item.DeletedAt == nil {
+			return idx, nil
+		}
+	}
+	return 0, errItemNotFound
+}
+
+// searchMode selects how Search matches the keyword.
+type searchMode string
+
+const (
+	// searchContains matches items whose name or category contains each of the terms in the keyword.
+	searchContains searchMode = "contains"
+	// searchPrefix matches items whose name starts with the keyword, for lookups as the user types.
+	searchPrefix searchMode = "prefix"
+)
+
+// Search returns items matching the keyword in the given mode. In searchContains, the keyword is split on whitespace,
+// and an item matches if each of the terms is contained in its name or category.
+// In searchPrefix, an item matches if its name starts with the whole keyword, spaces included.
+// Items are kept in a JSON file which is read as a whole on every call, so Search scans them in memory.
+// A full-text index such as SQLite's FTS5 needs the SQLite store (STEP 5) and isn't used here.
+func (i *itemRepository) Search(ctx context.Context, keyword string, mode searchMode) ([]*Item, error) {
 	items, err := i.List(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	//idがitem全体数より多いのはおかしいのでエラー
-	if len(items) < id {
+	terms := strings.Fields(keyword)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	if mode == searchPrefix {
+		prefix := strings.TrimSpace(keyword)
+		var result []*Item
+		for _, item := range items {
+			if strings.HasPrefix(item.Name, prefix) {
+				result = append(result, item)
+			}
+		}
+		return result, nil
+	}
+
+	//すべての単語を名前かカテゴリに含むitemだけを残す
+	var result []*Item
+	for _, item := range items {
+		matched := true
+		for _, term := range terms {
+			if !strings.Contains(item.Name, term) && !strings.Contains(item.Category, term) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			result = append(result, item)
+		}
+	}
+
+	return result, nil
+}
+
+// SearchPage returns up to limit items matching the keyword, skipping the first offset ones,
+// and the total number of matching items. If limit is 0, all the items after offset are returned.
+func (i *itemRepository) SearchPage(ctx context.Context, keyword string, mode searchMode, limit, offset int) ([]*Item, int, error) {
+	items, err := i.Search(ctx, keyword, mode)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := len(items)
+	if offset >= total {
+		return []*Item{}, total, nil
+	}
+	items = items[offset:]
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+
+	return items, total, nil
+}
+
+// Suggest returns up to limit distinct item names and category names starting with prefix, ignoring case.
+func (i *itemRepository) Suggest(ctx context.Context, prefix string, limit int) ([]string, error) {
+	items, err := i.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := []string{}
+	if prefix == "" {
+		return suggestions, nil
+	}
+
+	lowerPrefix := strings.ToLower(prefix)
+	seen := map[string]bool{}
+	for _, item := range items {
+		for _, candidate := range []string{item.Name, item.Category} {
+			if seen[candidate] || !strings.HasPrefix(strings.ToLower(candidate), lowerPrefix) {
+				continue
+			}
+			seen[candidate] = true
+			suggestions = append(suggestions, candidate)
+		}
+	}
+
+	slices.Sort(suggestions)
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	return suggestions, nil
+}
+
+// ListByCategory returns the items in the given category.
+// It returns an empty list if the category doesn't exist.
+func (i *itemRepository) ListByCategory(ctx context.Context, category string) ([]*Item, error) {
+	items, err := i.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*Item{}
+	for _, item := range items {
+		if item.Category == category {
+			result = append(result, item)
+		}
+	}
+
+	return result, nil
+}
+
+// ListBySeller returns the items whose SellerID is sellerID.
+// It returns an empty list if the seller has no items.
+func (i *itemRepository) ListBySeller(ctx context.Context, sellerID string) ([]*Item, error) {
+	items, err := i.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*Item{}
+	for _, item := range items {
+		if item.SellerID == sellerID {
+			result = append(result, item)
+		}
+	}
+
+	return result, nil
+}
+
+// ListSimilar returns up to limit items in the same category as the item with id, excluding the item itself.
+func (i *itemRepository) ListSimilar(ctx context.Context, id, limit int) ([]*Item, error) {
+	items, err := i.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := findItem(items, id)
+	if err != nil {
+		return nil, err
+	}
+	category := items[idx].Category
+
+	result := []*Item{}
+	for _, item := range items {
+		if len(result) >= limit {
+			break
+		}
+		if item.Category == category && item.ID != id {
+			result = append(result, item)
+		}
+	}
+
+	return result, nil
+}
+
+// Count returns the number of items.
+func (i *itemRepository) Count(ctx context.Context) (int, error) {
+	items, err := i.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(items), nil
+}
+
+// Delete deletes the item with the given id.
+// The item is kept with deleted_at set, so that it can be restored.
+func (i *itemRepository) Delete(ctx context.Context, id int) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	defer i.invalidateCache(id)
+
+	items, err := i.loadItems(ctx)
+	if err != nil {
+		return err
+	}
+
+	idx, err := findItem(items, id)
+	if err != nil {
+		return err
+	}
+
+	//行は残して、削除日時だけを記録する
+	now := time.Now().UTC()
+	items[idx].DeletedAt = &now
+	items[idx].UpdatedAt = now
+
+	return i.save(items)
+}
+
+// Restore clears deleted_at of the item with the given id. Restoring an item which isn't deleted does nothing.
+func (i *itemRepository) Restore(ctx context.Context, id int) (*Item, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	defer i.invalidateCache(id)
+
+	items, err := i.loadItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := slices.IndexFunc(items, func(item *Item) bool { return item.ID == id })
+	if idx < 0 {
 		return nil, errItemNotFound
 	}
+	item := items[idx]
+	if item.DeletedAt == nil {
+		return item, nil
+	}
+
+	//削除中に同じ name と category の item が登録されていたら戻せない
+	for _, it := range items {
+		if it.DeletedAt == nil && it.Name == item.Name && it.Category == item.Category {
+			return nil, errDuplicateItem
+		}
+	}
 
-	return items[id-1], nil
+	item.DeletedAt = nil
+	item.UpdatedAt = time.Now().UTC()
+	if err := i.save(items); err != nil {
+		return nil, err
+	}
 
+	return item, nil
+}
+
+// Update updates the name and category of the item with item.ID.
+// item.Version must be the version the client read, or 0 to skip the check, and the stored version is incremented.
+func (i *itemRepository) Update(ctx context.Context, item *Item) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	defer i.invalidateCache(item.ID)
+
+	items, err := i.loadItems(ctx)
+	if err != nil {
+		return err
+	}
+
+	idx, err := findItem(items, item.ID)
+	if err != nil {
+		return err
+	}
+
+	//読み込んだ後に他の更新があった場合は上書きしない
+	if item.Version != 0 && items[idx].Version != item.Version {
+		return errVersionConflict
+	}
+
+	// 同じ name と category の item には変更できない(自分自身と削除済みのitemは除く)
+	for _, it := range items {
+		if it.ID != item.ID && it.DeletedAt == nil && it.Name == item.Name && it.Category == item.Category {
+			return &duplicateItemError{ID: it.ID}
+		}
+	}
+
+	//画像はそのまま、名前とカテゴリだけ更新する
+	items[idx].Name = item.Name
+	items[idx].Category = item.Category
+	items[idx].UpdatedAt = time.Now().UTC()
+	items[idx].Version++
+
+	return i.save(items)
+}
+
+func (i *itemRepository) Purchase(ctx context.Context, id int) (*Item, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	defer i.invalidateCache(id)
+
+	items, err := i.loadItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := findItem(items, id)
+	if err != nil {
+		return nil, err
+	}
+
+	//在庫の確認と減算は同じロックの中で行う
+	if items[idx].Stock <= 0 {
+		return nil, errOutOfStock
+	}
+	items[idx].Stock--
+	items[idx].UpdatedAt = time.Now().UTC()
+	items[idx].Version++
+
+	if err := i.save(items); err != nil {
+		return nil, err
+	}
+
+	return items[idx], nil
+}
+
+// ReassignCategory changes the category of the items in from to into.
+// Categories exist only as names on items, so from disappears once its items are moved.
+func (i *itemRepository) ReassignCategory(ctx context.Context, from, into string) (int, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	items, err := i.loadItems(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	//すべて移せるか確認してから変更し、途中で失敗して一部だけ移った状態にならないようにする
+	var moved []*Item
+	for _, item := range items {
+		if item.DeletedAt != nil || item.Category != from {
+			continue
+		}
+		if slices.ContainsFunc(items, func(it *Item) bool {
+			return it.DeletedAt == nil && it.Category == into && it.Name == item.Name
+		}) {
+			return 0, errDuplicateItem
+		}
+		moved = append(moved, item)
+	}
+	if len(moved) == 0 {
+		return 0, nil
+	}
+
+	now := time.Now().UTC()
+	for _, item := range moved {
+		item.Category = into
+		item.UpdatedAt = now
+		item.Version++
+		defer i.invalidateCache(item.ID)
+	}
+
+	if err := i.save(items); err != nil {
+		return 0, err
+	}
+
+	return len(moved), nil
+}
+
+// ListCategories returns the distinct categories of the stored items.
+// Categories are kept as names on each item, so IDs are assigned in order of first appearance.
+func (i *itemRepository) ListCategories(ctx context.Context) ([]*Category, error) {
+	items, err := i.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	categories := []*Category{}
+	seen := map[string]*Category{}
+	for _, item := range items {
+		//カテゴリごとの item の数を数える
+		if c, ok := seen[item.Category]; ok {
+			c.ItemCount++
+			continue
+		}
+		c := &Category{ID: len(categories) + 1, Name: item.Category, ItemCount: 1}
+		seen[item.Category] = c
+		categories = append(categories, c)
+	}
+
+	return categories, nil
+}
+
+// load reads the JSON data of the items.
+// It returns an error satisfying os.IsNotExist if nothing has been saved yet.
+func (i *itemRepository) load() ([]byte, error) {
+	if i.fileName == "" {
+		i.memDataMu.RLock()
+		defer i.memDataMu.RUnlock()
+		if i.memData == nil {
+			return nil, os.ErrNotExist
+		}
+		return i.memData, nil
+	}
+
+	return os.ReadFile(i.fileName)
+}
+
+// save overwrites the JSON file with the given items.
+// The items are written to a temporary file which is then renamed over the JSON file,
+// so that a failure part way through never leaves a partially written file behind.
+func (i *itemRepository) save(items []*Item) error {
+	//image_urlなど計算で求まる値はファイルに保存しない
+	stored := make([]*storedItem, len(items))
+	for idx, item := range items {
+		stored[idx] = (*storedItem)(item)
+	}
+	data := struct {
+		Version int           `json:"version"`
+		Items   []*storedItem `json:"items"`
+	}{Version: len(itemMigrations), Items: stored}
+
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode items: %w", err)
+	}
+
+	if i.fileName == "" {
+		i.memDataMu.Lock()
+		defer i.memDataMu.Unlock()
+		i.memData = dataBytes
+		return nil
+	}
+
+	//一時ファイルの作成から置き換えまでを、ファイルが使用中の間は再試行する
+	err = retryOnBusy(context.Background(), repoBusyAttempts, repoBusyBackoff, func() error {
+		if err := replaceFile(i.fileName, dataBytes); err != nil {
+			if isFileBusy(err) {
+				return fmt.Errorf("%w: %w", errRepositoryBusy, err)
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return nil
+}
+
+// replaceFile writes data to a temporary file in the same directory as fileName and renames it over fileName.
+func replaceFile(fileName string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once the file has been renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	//renameは同じファイルシステム内ではアトミックに置き換わる
+	return os.Rename(tmp.Name(), fileName)
+}
+
+// repoBusyAttempts and repoBusyBackoff are how many times a write to the JSON file is tried
+// while another process has the file open, and how long to wait before the first retry.
+const (
+	repoBusyAttempts = 3
+	repoBusyBackoff  = 10 * time.Millisecond
+)
+
+// retryOnBusy calls fn up to attempts times while it returns errRepositoryBusy, doubling the wait from backoff
+// after each try. Any other error, such as errDuplicateItem, is returned right away, since trying again won't help.
+func retryOnBusy(ctx context.Context, attempts int, backoff time.Duration, fn func() error) error {
+	var err error
+	for attempt := range attempts {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff << (attempt - 1)):
+			}
+		}
+		if err = fn(); !errors.Is(err, errRepositoryBusy) {
+			return err
+		}
+	}
+	return err
 }
 
 // StoreImage stores an image and returns an error if any.
 // This package doesn't have a related interface for simplicity.
+// The image is written to a temporary file in the same directory which is then renamed to fileName,
+// so that a crash part way through never leaves a truncated image at fileName.
 func StoreImage(fileName string, image []byte) error {
 	// STEP 4-4: add an implementation to store an image
-	if err := os.WriteFile(fileName, image, 0644); err != nil {
+	tmp, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once the file has been renamed
+
+	if _, err := tmp.Write(image); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write image file: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write image file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write image file: %w", err)
+	}
+
+	//同じファイルシステム内のrenameはアトミックなので、fileNameには完全な画像しか現れない
+	if err := os.Rename(tmp.Name(), fileName); err != nil {
 		return fmt.Errorf("failed to write image file: %w", err)
 	}
 
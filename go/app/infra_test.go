@@ -0,0 +1,1323 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// newTestItemRepository returns an itemRepository backed by a temporary JSON file.
+func newTestItemRepository(t *testing.T) *itemRepository {
+	t.Helper()
+
+	return &itemRepository{fileName: filepath.Join(t.TempDir(), "items.json")}
+}
+
+func TestItemRepositoryTimestamps(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	item := &Item{Name: "jacket", Category: "fashion", ImageName: "default.jpg"}
+	if _, err := repo.Insert(ctx, item); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+
+	got, err := repo.Select(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+	if got.CreatedAt.IsZero() {
+		t.Errorf("expected created_at to be set")
+	}
+	if !got.UpdatedAt.Equal(got.CreatedAt) {
+		t.Errorf("expected updated_at %v to equal created_at %v", got.UpdatedAt, got.CreatedAt)
+	}
+
+	if err := repo.Update(ctx, &Item{ID: 1, Name: "coat", Category: "fashion", Version: 1}); err != nil {
+		t.Fatalf("failed to update item: %v", err)
+	}
+
+	updated, err := repo.Select(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+	if !updated.CreatedAt.Equal(got.CreatedAt) {
+		t.Errorf("expected created_at to stay %v, got %v", got.CreatedAt, updated.CreatedAt)
+	}
+	if updated.UpdatedAt.Before(got.UpdatedAt) {
+		t.Errorf("expected updated_at to move forward from %v, got %v", got.UpdatedAt, updated.UpdatedAt)
+	}
+}
+
+func TestItemRepositoryListCategories(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	for _, item := range []*Item{
+		{Name: "jacket", Category: "fashion"},
+		{Name: "iPhone", Category: "phone"},
+		{Name: "coat", Category: "fashion"},
+	} {
+		if _, err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	got, err := repo.ListCategories(ctx)
+	if err != nil {
+		t.Fatalf("failed to list categories: %v", err)
+	}
+
+	want := []*Category{
+		{ID: 1, Name: "fashion", ItemCount: 2},
+		{ID: 2, Name: "phone", ItemCount: 1},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected categories (-want +got):\n%s", diff)
+	}
+}
+
+func TestItemRepositoryIDsAreStable(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	for _, name := range []string{"jacket", "coat", "shirt"} {
+		if _, err := repo.Insert(ctx, &Item{Name: name, Category: "fashion"}); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	if err := repo.Delete(ctx, 2); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	// ids of the remaining items must not shift after a deletion
+	got, err := repo.Select(ctx, 3)
+	if err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+	if got.Name != "shirt" {
+		t.Errorf("expected item 3 to be shirt, got %s", got.Name)
+	}
+	if _, err := repo.Select(ctx, 2); !errors.Is(err, errItemNotFound) {
+		t.Errorf("expected errItemNotFound for deleted item, got %v", err)
+	}
+
+	id, err := repo.Insert(ctx, &Item{Name: "hat", Category: "fashion"})
+	if err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	if id != 4 {
+		t.Errorf("expected new id 4, got %d", id)
+	}
+}
+
+func TestItemRepositorySaveIsAtomic(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+	dir := filepath.Dir(repo.fileName)
+
+	if _, err := repo.Insert(ctx, &Item{Name: "jacket", Category: "fashion"}); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+
+	// make the final rename fail by putting a non-empty directory in the way
+	blocked := &itemRepository{fileName: filepath.Join(dir, "blocked")}
+	if err := os.MkdirAll(filepath.Join(blocked.fileName, "child"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := blocked.save([]*Item{{ID: 1, Name: "coat", Category: "fashion"}}); err == nil {
+		t.Fatalf("expected save to fail")
+	}
+
+	// no temporary files are left behind, and the existing data is untouched
+	tmps, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if err != nil {
+		t.Fatalf("failed to list temporary files: %v", err)
+	}
+	if len(tmps) != 0 {
+		t.Errorf("expected no temporary files, got %v", tmps)
+	}
+
+	items, err := repo.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	if len(items) != 1 || items[0].Name != "jacket" {
+		t.Errorf("unexpected items after failed save: %v", items)
+	}
+}
+
+func TestItemRepositoryConcurrentInserts(t *testing.T) {
+	t.Parallel()
+
+	const n = 20
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for j := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.Insert(ctx, &Item{Name: fmt.Sprintf("item%d", j), Category: "fashion"})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("failed to insert item: %v", err)
+		}
+	}
+
+	items, err := repo.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	if len(items) != n {
+		t.Fatalf("expected %d items, got %d", n, len(items))
+	}
+	ids := map[int]bool{}
+	for _, item := range items {
+		if ids[item.ID] {
+			t.Errorf("duplicate id %d", item.ID)
+		}
+		ids[item.ID] = true
+	}
+}
+
+func TestItemRepositoryListByCategory(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	for _, item := range []*Item{
+		{Name: "jacket", Category: "fashion"},
+		{Name: "iPhone", Category: "phone"},
+	} {
+		if _, err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	got, err := repo.ListByCategory(ctx, "fashion")
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	if len(got) != 1 || got[0].Name != "jacket" {
+		t.Errorf("unexpected items: %v", got)
+	}
+
+	// an unknown category is not an error
+	got, err = repo.ListByCategory(ctx, "unknown")
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	if got == nil || len(got) != 0 {
+		t.Errorf("expected an empty list, got %v", got)
+	}
+}
+
+func TestNewItemRepositoryFromEnv(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "items.json")
+
+	cases := map[string]struct {
+		backend  string
+		wantFile string
+		err      bool
+	}{
+		"ok: file backend": {
+			backend:  "file",
+			wantFile: dbPath,
+		},
+		"ok: memory backend": {
+			backend:  "memory",
+			wantFile: "",
+		},
+		"ng: unknown backend": {
+			backend: "sqlite",
+			err:     true,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("REPO_BACKEND", tt.backend)
+			t.Setenv("DB_PATH", dbPath)
+
+			repo, err := NewItemRepositoryFromEnv()
+			if err != nil {
+				if !tt.err {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if tt.err {
+				t.Fatalf("expected an error, got nil")
+			}
+			if got := repo.(*itemRepository).fileName; got != tt.wantFile {
+				t.Errorf("expected file %q, got %q", tt.wantFile, got)
+			}
+		})
+	}
+}
+
+func TestNewItemRepositoryFromEnvCacheSize(t *testing.T) {
+	// not parallel because it sets environment variables
+
+	cases := map[string]struct {
+		size      string
+		wantCache bool
+		err       bool
+	}{
+		"ok: cache enabled": {
+			size:      "10",
+			wantCache: true,
+		},
+		"ok: 0 disables the cache": {
+			size:      "0",
+			wantCache: false,
+		},
+		"ng: negative size": {
+			size: "-1",
+			err:  true,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("REPO_BACKEND", "memory")
+			t.Setenv("ITEM_CACHE_SIZE", tt.size)
+
+			repo, err := NewItemRepositoryFromEnv()
+			if err != nil {
+				if !tt.err {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if tt.err {
+				t.Fatalf("expected an error, got nil")
+			}
+			if got := repo.(*itemRepository).cache != nil; got != tt.wantCache {
+				t.Errorf("expected cache %v, got %v", tt.wantCache, got)
+			}
+		})
+	}
+}
+
+func TestMemoryItemRepository(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewMemoryItemRepository()
+
+	items, err := repo.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	if len(items) != 0 {
+		t.Errorf("expected no items, got %v", items)
+	}
+
+	id, err := repo.Insert(ctx, &Item{Name: "jacket", Category: "fashion"})
+	if err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+
+	// items returned by the repository are copies, so modifying them doesn't change the stored data
+	got, err := repo.Select(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+	got.Name = "changed"
+
+	got, err = repo.Select(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+	if got.Name != "jacket" {
+		t.Errorf("expected stored name jacket, got %s", got.Name)
+	}
+}
+
+func TestItemRepositoryCanceledContext(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestItemRepository(t)
+	if _, err := repo.Insert(context.Background(), &Item{Name: "jacket", Category: "fashion"}); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := repo.List(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("List: expected context.Canceled, got %v", err)
+	}
+	if _, err := repo.Select(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("Select: expected context.Canceled, got %v", err)
+	}
+	if _, err := repo.Search(ctx, "jacket", searchContains); !errors.Is(err, context.Canceled) {
+		t.Errorf("Search: expected context.Canceled, got %v", err)
+	}
+	if _, err := repo.Insert(ctx, &Item{Name: "shoes", Category: "fashion"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Insert: expected context.Canceled, got %v", err)
+	}
+}
+
+func TestItemRepositoryInsertDuplicate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	id, err := repo.Insert(ctx, &Item{Name: "jacket", Category: "fashion"})
+	if err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+
+	got, err := repo.Insert(ctx, &Item{Name: "jacket", Category: "fashion"})
+	if !errors.Is(err, errDuplicateItem) {
+		t.Fatalf("expected errDuplicateItem, got %v", err)
+	}
+	if got != id {
+		t.Errorf("expected existing id %d, got %d", id, got)
+	}
+
+	// the same name in another category is a different item
+	if _, err := repo.Insert(ctx, &Item{Name: "jacket", Category: "outdoor"}); err != nil {
+		t.Errorf("failed to insert item: %v", err)
+	}
+}
+
+func TestItemRepositoryInsertBatch(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	if _, err := repo.Insert(ctx, &Item{Name: "jacket", Category: "fashion"}); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+
+	ids, err := repo.InsertBatch(ctx, []*Item{
+		{Name: "iPhone", Category: "phone"},
+		{Name: "coat", Category: "fashion"},
+	})
+	if err != nil {
+		t.Fatalf("failed to insert items: %v", err)
+	}
+	if diff := cmp.Diff([]int{2, 3}, ids); diff != "" {
+		t.Errorf("unexpected ids (-want +got):\n%s", diff)
+	}
+
+	// a duplicate in the batch rolls back the whole batch
+	_, err = repo.InsertBatch(ctx, []*Item{
+		{Name: "shoes", Category: "fashion"},
+		{Name: "jacket", Category: "fashion"},
+	})
+	var batchErr *batchError
+	if !errors.As(err, &batchErr) || !errors.Is(err, errDuplicateItem) {
+		t.Fatalf("expected a batchError of errDuplicateItem, got %v", err)
+	}
+	if batchErr.Index != 1 || batchErr.ID != 1 {
+		t.Errorf("expected index 1 and id 1, got index %d and id %d", batchErr.Index, batchErr.ID)
+	}
+
+	count, err := repo.Count(ctx)
+	if err != nil {
+		t.Fatalf("failed to count items: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 items after the rollback, got %d", count)
+	}
+}
+
+func TestItemRepositorySuggest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	for _, item := range []*Item{
+		{Name: "jacket", Category: "fashion"},
+		{Name: "Jacquard scarf", Category: "fashion"},
+		{Name: "jam", Category: "food"},
+		{Name: "coat", Category: "jackets"},
+	} {
+		if _, err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	cases := map[string]struct {
+		prefix string
+		limit  int
+		want   []string
+	}{
+		"ok: names and categories": {
+			prefix: "jac",
+			limit:  10,
+			want:   []string{"Jacquard scarf", "jacket", "jackets"},
+		},
+		"ok: limited": {
+			prefix: "ja",
+			limit:  2,
+			want:   []string{"Jacquard scarf", "jacket"},
+		},
+		"ok: distinct": {
+			prefix: "fa",
+			limit:  10,
+			want:   []string{"fashion"},
+		},
+		"ok: empty prefix": {
+			prefix: "",
+			limit:  10,
+			want:   []string{},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := repo.Suggest(ctx, tt.prefix, tt.limit)
+			if err != nil {
+				t.Fatalf("failed to get suggestions: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected suggestions (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestItemRepositorySearchPage(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	for _, item := range []*Item{
+		{Name: "red jacket", Category: "fashion"},
+		{Name: "iPhone", Category: "phone"},
+		{Name: "blue jacket", Category: "fashion"},
+		{Name: "green jacket", Category: "fashion"},
+	} {
+		if _, err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	cases := map[string]struct {
+		limit, offset int
+		want          []string
+	}{
+		"ok: all":          {limit: 0, offset: 0, want: []string{"red jacket", "blue jacket", "green jacket"}},
+		"ok: first page":   {limit: 2, offset: 0, want: []string{"red jacket", "blue jacket"}},
+		"ok: second page":  {limit: 2, offset: 2, want: []string{"green jacket"}},
+		"ok: out of range": {limit: 2, offset: 4, want: []string{}},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			items, total, err := repo.SearchPage(ctx, "jacket", searchContains, tt.limit, tt.offset)
+			if err != nil {
+				t.Fatalf("failed to search items: %v", err)
+			}
+			if total != 3 {
+				t.Errorf("expected total 3, got %d", total)
+			}
+			got := []string{}
+			for _, item := range items {
+				got = append(got, item.Name)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected items (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestItemRepositorySearch(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	for _, item := range []*Item{
+		{Name: "red jacket", Category: "fashion"},
+		{Name: "blue jacket", Category: "fashion"},
+		{Name: "red iPhone", Category: "phone"},
+	} {
+		if _, err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	cases := map[string]struct {
+		keyword string
+		mode    searchMode
+		want    []string
+	}{
+		"ok: single term":              {keyword: "jacket", mode: searchContains, want: []string{"red jacket", "blue jacket"}},
+		"ok: all the terms":            {keyword: "red jacket", mode: searchContains, want: []string{"red jacket"}},
+		"ok: name and category":        {keyword: "red  phone", mode: searchContains, want: []string{"red iPhone"}},
+		"ok: no item has all terms":    {keyword: "blue phone", mode: searchContains, want: []string{}},
+		"ok: blank keyword":            {keyword: " ", mode: searchContains, want: []string{}},
+		"ok: prefix":                   {keyword: "red", mode: searchPrefix, want: []string{"red jacket", "red iPhone"}},
+		"ok: prefix with a space":      {keyword: "red j", mode: searchPrefix, want: []string{"red jacket"}},
+		"ok: prefix not in the middle": {keyword: "jacket", mode: searchPrefix, want: []string{}},
+		"ok: prefix ignores category":  {keyword: "fashion", mode: searchPrefix, want: []string{}},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			items, err := repo.Search(ctx, tt.keyword, tt.mode)
+			if err != nil {
+				t.Fatalf("failed to search items: %v", err)
+			}
+			got := []string{}
+			for _, item := range items {
+				got = append(got, item.Name)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected items (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestItemMarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	item := &Item{Name: "jacket", Category: "fashion", ImageName: "abc.jpg"}
+	if _, err := repo.Insert(ctx, item); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+
+	b, err := json.Marshal(item)
+	if err != nil {
+		t.Fatalf("failed to encode item: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("failed to decode item: %v", err)
+	}
+	if got["image"] != "abc.jpg" || got["image_url"] != "/images/abc.jpg" {
+		t.Errorf("expected image abc.jpg and image_url /images/abc.jpg, got %v and %v", got["image"], got["image_url"])
+	}
+	// items with only ImageName have it as their only image
+	if diff := cmp.Diff([]any{"/images/abc.jpg"}, got["images"]); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+
+	b, err = json.Marshal(&Item{Name: "coat", Category: "fashion", ImageName: "abc.jpg", ImageNames: []string{"abc.jpg", "def.png"}})
+	if err != nil {
+		t.Fatalf("failed to encode item: %v", err)
+	}
+	got = nil
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("failed to decode item: %v", err)
+	}
+	if diff := cmp.Diff([]any{"/images/abc.jpg", "/images/def.png"}, got["images"]); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+
+	// image_url and images are computed, so they aren't saved in the file
+	data, err := os.ReadFile(repo.fileName)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if strings.Contains(string(data), "image_url") || strings.Contains(string(data), `"images"`) {
+		t.Errorf("expected image_url and images not to be saved, got %s", data)
+	}
+}
+
+func TestItemRepositoryMigrateSchema(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("ok: old file is rewritten", func(t *testing.T) {
+		t.Parallel()
+
+		repo := newTestItemRepository(t)
+		legacy := `{"items":[{"name":"jacket","category":"fashion"},{"name":"iPhone","category":"phone"}]}`
+		if err := os.WriteFile(repo.fileName, []byte(legacy), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+
+		if err := repo.MigrateSchema(ctx); err != nil {
+			t.Fatalf("failed to migrate: %v", err)
+		}
+
+		dataBytes, err := os.ReadFile(repo.fileName)
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		var data struct {
+			Version int `json:"version"`
+			Items   []struct {
+				ID    int `json:"id"`
+				Stock int `json:"stock"`
+			} `json:"items"`
+		}
+		if err := json.Unmarshal(dataBytes, &data); err != nil {
+			t.Fatalf("failed to decode file: %v", err)
+		}
+		if data.Version != len(itemMigrations) {
+			t.Errorf("expected version %d, got %d", len(itemMigrations), data.Version)
+		}
+		if len(data.Items) != 2 || data.Items[0].ID != 1 || data.Items[1].ID != 2 {
+			t.Errorf("expected ids 1 and 2 to be saved, got %s", dataBytes)
+		}
+		if data.Items[0].Stock != defaultStock {
+			t.Errorf("expected stock %d for an item listed before stocks, got %d", defaultStock, data.Items[0].Stock)
+		}
+	})
+
+	t.Run("ok: missing file", func(t *testing.T) {
+		t.Parallel()
+
+		repo := newTestItemRepository(t)
+		if err := repo.MigrateSchema(ctx); err != nil {
+			t.Fatalf("failed to migrate: %v", err)
+		}
+		if _, err := os.Stat(repo.fileName); !os.IsNotExist(err) {
+			t.Errorf("expected no file to be created, got %v", err)
+		}
+	})
+
+	t.Run("ng: newer version", func(t *testing.T) {
+		t.Parallel()
+
+		repo := newTestItemRepository(t)
+		if err := os.WriteFile(repo.fileName, []byte(`{"version":999,"items":[]}`), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		if err := repo.MigrateSchema(ctx); err == nil {
+			t.Errorf("expected an error for an unsupported version")
+		}
+	})
+}
+
+func TestItemRepositoryPurchase(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	id, err := repo.Insert(ctx, &Item{Name: "jacket", Category: "fashion", Stock: 2})
+	if err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+
+	for _, want := range []int{1, 0} {
+		item, err := repo.Purchase(ctx, id)
+		if err != nil {
+			t.Fatalf("failed to purchase item: %v", err)
+		}
+		if item.Stock != want {
+			t.Errorf("expected stock %d, got %d", want, item.Stock)
+		}
+	}
+
+	if _, err := repo.Purchase(ctx, id); !errors.Is(err, errOutOfStock) {
+		t.Errorf("expected errOutOfStock, got %v", err)
+	}
+	if _, err := repo.Purchase(ctx, id+1); !errors.Is(err, errItemNotFound) {
+		t.Errorf("expected errItemNotFound, got %v", err)
+	}
+}
+
+func TestItemRepositorySelectCache(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+	repo.cache = newItemCache(defaultItemCacheSize)
+
+	id, err := repo.Insert(ctx, &Item{Name: "jacket", Category: "fashion", Stock: 2})
+	if err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	if _, err := repo.Select(ctx, id); err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+
+	// the second Select must not read the file, so breaking it goes unnoticed
+	valid, err := os.ReadFile(repo.fileName)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if err := os.WriteFile(repo.fileName, []byte("broken"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	item, err := repo.Select(ctx, id)
+	if err != nil {
+		t.Fatalf("expected the cached item, got error: %v", err)
+	}
+	if item.Name != "jacket" {
+		t.Errorf("expected name jacket, got %s", item.Name)
+	}
+	if err := os.WriteFile(repo.fileName, valid, 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	// writes invalidate the cached item
+	if err := repo.Update(ctx, &Item{ID: id, Name: "coat", Category: "fashion", Version: 1}); err != nil {
+		t.Fatalf("failed to update item: %v", err)
+	}
+	if item, err := repo.Select(ctx, id); err != nil || item.Name != "coat" {
+		t.Errorf("expected the updated item, got %+v, %v", item, err)
+	}
+	if _, err := repo.Purchase(ctx, id); err != nil {
+		t.Fatalf("failed to purchase item: %v", err)
+	}
+	if item, err := repo.Select(ctx, id); err != nil || item.Stock != 1 {
+		t.Errorf("expected the purchased item, got %+v, %v", item, err)
+	}
+	if err := repo.Delete(ctx, id); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+	if _, err := repo.Select(ctx, id); !errors.Is(err, errItemNotFound) {
+		t.Errorf("expected errItemNotFound, got %v", err)
+	}
+}
+
+func TestItemCacheEviction(t *testing.T) {
+	t.Parallel()
+
+	c := newItemCache(2)
+	c.add(&Item{ID: 1}, c.generation())
+	c.add(&Item{ID: 2}, c.generation())
+	c.get(1) // 1 is now used more recently than 2
+	c.add(&Item{ID: 3}, c.generation())
+
+	for id, want := range map[int]bool{1: true, 2: false, 3: true} {
+		if _, ok := c.get(id); ok != want {
+			t.Errorf("expected cached %v for item %d, got %v", want, id, ok)
+		}
+	}
+
+	// an item read before an invalidation is not cached
+	gen := c.generation()
+	c.invalidate(1)
+	c.add(&Item{ID: 4}, gen)
+	if _, ok := c.get(4); ok {
+		t.Errorf("expected item 4 not to be cached")
+	}
+}
+
+func TestItemCacheCopies(t *testing.T) {
+	t.Parallel()
+
+	newItem := func() *Item {
+		deletedAt := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+		return &Item{ID: 1, Name: "jacket", Tags: []string{"winter"}, ImageNames: []string{"a.jpg", "b.jpg"}, DeletedAt: &deletedAt}
+	}
+	c := newItemCache(2)
+	c.add(newItem(), c.generation())
+
+	// modifying the returned item doesn't change the cached one
+	got, _ := c.get(1)
+	got.Tags[0] = "summer"
+	got.ImageNames[0] = "c.jpg"
+	*got.DeletedAt = time.Time{}
+
+	got, _ = c.get(1)
+	if diff := cmp.Diff(newItem(), got); diff != "" {
+		t.Errorf("unexpected cached item (-want +got):\n%s", diff)
+	}
+}
+
+func TestItemRepositoryUpdateVersion(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	id, err := repo.Insert(ctx, &Item{Name: "jacket", Category: "fashion", Stock: 1})
+	if err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+
+	// two clients read version 1, and only the first update succeeds
+	if err := repo.Update(ctx, &Item{ID: id, Name: "coat", Category: "fashion", Version: 1}); err != nil {
+		t.Fatalf("failed to update item: %v", err)
+	}
+	if err := repo.Update(ctx, &Item{ID: id, Name: "parka", Category: "fashion", Version: 1}); !errors.Is(err, errVersionConflict) {
+		t.Errorf("expected errVersionConflict, got %v", err)
+	}
+
+	item, err := repo.Select(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+	if item.Name != "coat" || item.Version != 2 {
+		t.Errorf("expected coat at version 2, got %s at version %d", item.Name, item.Version)
+	}
+
+	// version 0 updates whatever the version is
+	if err := repo.Update(ctx, &Item{ID: id, Name: "parka", Category: "fashion"}); err != nil {
+		t.Fatalf("failed to update item without version: %v", err)
+	}
+	if err := repo.Update(ctx, &Item{ID: id + 1, Name: "parka", Category: "fashion"}); !errors.Is(err, errItemNotFound) {
+		t.Errorf("expected errItemNotFound, got %v", err)
+	}
+
+	if _, err := repo.Purchase(ctx, id); err != nil {
+		t.Fatalf("failed to purchase item: %v", err)
+	}
+	if item, err := repo.Select(ctx, id); err != nil || item.Version != 4 {
+		t.Errorf("expected version 4 after purchase, got %+v, %v", item, err)
+	}
+}
+
+func TestItemRepositoryUpdateDuplicate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	jacketID, err := repo.Insert(ctx, &Item{Name: "jacket", Category: "fashion"})
+	if err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	coatID, err := repo.Insert(ctx, &Item{Name: "coat", Category: "fashion"})
+	if err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	parkaID, err := repo.Insert(ctx, &Item{Name: "parka", Category: "fashion"})
+	if err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	if err := repo.Delete(ctx, parkaID); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	// renaming onto an active item is refused with its id
+	var dupErr *duplicateItemError
+	err = repo.Update(ctx, &Item{ID: coatID, Name: "jacket", Category: "fashion", Version: 1})
+	if !errors.As(err, &dupErr) || !errors.Is(err, errDuplicateItem) {
+		t.Fatalf("expected duplicateItemError, got %v", err)
+	}
+	if dupErr.ID != jacketID {
+		t.Errorf("expected existing id %d, got %d", jacketID, dupErr.ID)
+	}
+	if item, err := repo.Select(ctx, coatID); err != nil || item.Name != "coat" || item.Version != 1 {
+		t.Errorf("expected the item to be unchanged, got %+v, %v", item, err)
+	}
+
+	// the item itself and deleted items don't count
+	if err := repo.Update(ctx, &Item{ID: coatID, Name: "coat", Category: "fashion", Version: 1}); err != nil {
+		t.Errorf("failed to update item with its own name: %v", err)
+	}
+	if err := repo.Update(ctx, &Item{ID: coatID, Name: "parka", Category: "fashion", Version: 2}); err != nil {
+		t.Errorf("failed to update item with the name of a deleted item: %v", err)
+	}
+}
+
+func TestItemRepositorySelectRandom(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	if _, err := repo.SelectRandom(ctx); !errors.Is(err, errItemNotFound) {
+		t.Errorf("expected errItemNotFound for no items, got %v", err)
+	}
+
+	names := map[string]bool{"jacket": true, "coat": true, "parka": true}
+	for name := range names {
+		if _, err := repo.Insert(ctx, &Item{Name: name, Category: "fashion"}); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	for range 10 {
+		item, err := repo.SelectRandom(ctx)
+		if err != nil {
+			t.Fatalf("failed to select random item: %v", err)
+		}
+		if !names[item.Name] {
+			t.Errorf("unexpected item %s", item.Name)
+		}
+	}
+}
+
+func TestItemRepositoryListSimilar(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	for _, item := range []*Item{
+		{Name: "jacket", Category: "fashion"},
+		{Name: "coat", Category: "fashion"},
+		{Name: "parka", Category: "fashion"},
+		{Name: "iPhone", Category: "phone"},
+	} {
+		if _, err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	cases := map[string]struct {
+		id    int
+		limit int
+		want  []string
+		err   error
+	}{
+		"ok: other items in the category": {
+			id:    1,
+			limit: 10,
+			want:  []string{"coat", "parka"},
+		},
+		"ok: limited": {
+			id:    2,
+			limit: 1,
+			want:  []string{"jacket"},
+		},
+		"ok: no other items": {
+			id:    4,
+			limit: 10,
+			want:  []string{},
+		},
+		"ng: item not found": {
+			id:    5,
+			limit: 10,
+			err:   errItemNotFound,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			items, err := repo.ListSimilar(ctx, tt.id, tt.limit)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if tt.err != nil {
+				return
+			}
+
+			got := []string{}
+			for _, item := range items {
+				got = append(got, item.Name)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected items (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestItemRepositorySoftDelete(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	id, err := repo.Insert(ctx, &Item{Name: "jacket", Category: "fashion"})
+	if err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	if _, err := repo.Insert(ctx, &Item{Name: "coat", Category: "fashion"}); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+
+	if err := repo.Delete(ctx, id); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	// the deleted item is hidden from reads
+	if _, err := repo.Select(ctx, id); !errors.Is(err, errItemNotFound) {
+		t.Errorf("expected errItemNotFound from Select, got %v", err)
+	}
+	items, err := repo.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	if len(items) != 1 || items[0].Name != "coat" {
+		t.Errorf("expected only coat to be listed, got %v", items)
+	}
+	if found, err := repo.Search(ctx, "jacket", searchContains); err != nil || len(found) != 0 {
+		t.Errorf("expected no search results, got %v, %v", found, err)
+	}
+	if err := repo.Delete(ctx, id); !errors.Is(err, errItemNotFound) {
+		t.Errorf("expected errItemNotFound deleting twice, got %v", err)
+	}
+
+	// but kept in the file, so a later write doesn't lose it
+	if _, err := repo.Insert(ctx, &Item{Name: "parka", Category: "fashion"}); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	all, err := repo.ListAll(ctx)
+	if err != nil {
+		t.Fatalf("failed to load items: %v", err)
+	}
+	if len(all) != 3 || all[0].DeletedAt == nil {
+		t.Errorf("expected the deleted item to be stored, got %v", all)
+	}
+
+	restored, err := repo.Restore(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to restore item: %v", err)
+	}
+	if restored.DeletedAt != nil {
+		t.Errorf("expected deleted_at to be cleared, got %v", restored.DeletedAt)
+	}
+	if _, err := repo.Select(ctx, id); err != nil {
+		t.Errorf("expected the restored item, got %v", err)
+	}
+	if _, err := repo.Restore(ctx, 10); !errors.Is(err, errItemNotFound) {
+		t.Errorf("expected errItemNotFound for a missing item, got %v", err)
+	}
+
+	// an item added with the same name while deleted prevents restoring it
+	if err := repo.Delete(ctx, id); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+	if _, err := repo.Insert(ctx, &Item{Name: "jacket", Category: "fashion"}); err != nil {
+		t.Fatalf("expected a deleted item not to be a duplicate, got %v", err)
+	}
+	if _, err := repo.Restore(ctx, id); !errors.Is(err, errDuplicateItem) {
+		t.Errorf("expected errDuplicateItem, got %v", err)
+	}
+}
+
+func TestItemRepositoryListBySeller(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	for _, item := range []*Item{
+		{Name: "jacket", Category: "fashion", SellerID: "seller1"},
+		{Name: "coat", Category: "fashion", SellerID: "seller2"},
+		{Name: "iPhone", Category: "phone", SellerID: "seller1"},
+	} {
+		if _, err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	got, err := repo.ListBySeller(ctx, "seller1")
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	if len(got) != 2 || got[0].Name != "jacket" || got[1].Name != "iPhone" {
+		t.Errorf("unexpected items: %v", got)
+	}
+
+	// a seller without items is not an error
+	got, err = repo.ListBySeller(ctx, "seller3")
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	if got == nil || len(got) != 0 {
+		t.Errorf("expected an empty list, got %v", got)
+	}
+}
+
+func TestItemRepositoryReassignCategory(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	for _, item := range []*Item{
+		{Name: "jacket", Category: "fashion"},
+		{Name: "coat", Category: "fashion"},
+		{Name: "shirt", Category: "clothes"},
+		{Name: "shirt", Category: "tops"},
+	} {
+		if _, err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	moved, err := repo.ReassignCategory(ctx, "fashion", "clothes")
+	if err != nil {
+		t.Fatalf("failed to reassign category: %v", err)
+	}
+	if moved != 2 {
+		t.Errorf("expected 2 items moved, got %d", moved)
+	}
+	items, err := repo.ListByCategory(ctx, "clothes")
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	if len(items) != 3 {
+		t.Errorf("expected 3 items in clothes, got %v", items)
+	}
+	// moving is a change, so the version is incremented
+	item, err := repo.Select(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+	if item.Category != "clothes" || item.Version != 2 {
+		t.Errorf("expected category clothes and version 2, got %s and %d", item.Category, item.Version)
+	}
+
+	// nothing is moved if a name would be duplicated in the target
+	if _, err := repo.ReassignCategory(ctx, "tops", "clothes"); !errors.Is(err, errDuplicateItem) {
+		t.Errorf("expected errDuplicateItem, got %v", err)
+	}
+	items, err = repo.ListByCategory(ctx, "tops")
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	if len(items) != 1 {
+		t.Errorf("expected the item to stay in tops, got %v", items)
+	}
+}
+
+func TestRetryOnBusy(t *testing.T) {
+	t.Parallel()
+
+	busy := fmt.Errorf("%w: file is open", errRepositoryBusy)
+
+	type wants struct {
+		calls int
+		err   error
+	}
+	cases := map[string]struct {
+		errs []error
+		wants
+	}{
+		"ok: succeeds at once": {
+			errs: []error{nil},
+			wants: wants{
+				calls: 1,
+			},
+		},
+		"ok: retries while busy then succeeds": {
+			errs: []error{busy, busy, nil},
+			wants: wants{
+				calls: 3,
+			},
+		},
+		"ng: gives up after the attempts": {
+			errs: []error{busy, busy, busy},
+			wants: wants{
+				calls: 3,
+				err:   errRepositoryBusy,
+			},
+		},
+		"ng: logical errors are not retried": {
+			errs: []error{errDuplicateItem},
+			wants: wants{
+				calls: 1,
+				err:   errDuplicateItem,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			calls := 0
+			err := retryOnBusy(context.Background(), 3, time.Millisecond, func() error {
+				calls++
+				if calls > len(tt.errs) {
+					t.Fatalf("unexpected call %d", calls)
+				}
+				return tt.errs[calls-1]
+			})
+
+			if !errors.Is(err, tt.wants.err) || (err == nil) != (tt.wants.err == nil) {
+				t.Errorf("expected error %v, got %v", tt.wants.err, err)
+			}
+			if calls != tt.wants.calls {
+				t.Errorf("expected %d calls, got %d", tt.wants.calls, calls)
+			}
+		})
+	}
+
+	t.Run("ng: stops waiting when the context is done", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		calls := 0
+		err := retryOnBusy(ctx, 3, time.Hour, func() error {
+			calls++
+			return busy
+		})
+
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+		if calls != 1 {
+			t.Errorf("expected 1 call, got %d", calls)
+		}
+	})
+}
+
+func TestItemRepositorySelect(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := newTestItemRepository(t)
+
+	items := []*Item{
+		{Name: "jacket", Category: "fashion", ImageName: "abc.jpg", Price: 1000, Stock: 1},
+		{Name: "iPhone", Category: "phone", ImageName: "def.jpg", Price: 50000, Stock: 1},
+	}
+	for _, item := range items {
+		if _, err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	// a new repository reads the items back from the file
+	reopened := &itemRepository{fileName: repo.fileName}
+	for _, want := range items {
+		got, err := reopened.Select(ctx, want.ID)
+		if err != nil {
+			t.Fatalf("failed to select item %d: %v", want.ID, err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("unexpected item %d (-want +got):\n%s", want.ID, diff)
+		}
+	}
+
+	if _, err := reopened.Select(ctx, 100); !errors.Is(err, errItemNotFound) {
+		t.Errorf("expected errItemNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	t.Parallel()
+
+	reg := prometheus.NewRegistry()
+	m := newMetrics(reg)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") == "404" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	mux.Handle("GET /metrics", metricsHandler(reg))
+	h := metricsMiddleware(mux, m)
+
+	for _, path := range []string{"/items/1", "/items/2", "/items/404", "/unknown"} {
+		req := httptest.NewRequest("GET", path, nil)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// requests are labeled by the route pattern, not by the path
+	cases := map[string]struct {
+		labels []string
+		want   float64
+	}{
+		"ok: found items": {
+			labels: []string{"GET", "/items/{id}", "200"},
+			want:   2,
+		},
+		"ok: missing item": {
+			labels: []string{"GET", "/items/{id}", "404"},
+			want:   1,
+		},
+		"ok: unmatched path": {
+			labels: []string{"GET", "unmatched", "404"},
+			want:   1,
+		},
+	}
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := testutil.ToFloat64(m.requests.WithLabelValues(tt.labels...)); got != tt.want {
+				t.Errorf("expected %v requests, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if got := testutil.ToFloat64(m.inFlight); got != 0 {
+		t.Errorf("expected no requests in flight, got %v", got)
+	}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="/items/{id}",status="200"} 2`,
+		`http_request_duration_seconds_count{method="GET",route="/items/{id}",status="200"} 2`,
+		"http_requests_in_flight 1",
+	} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("expected metrics to contain %s", want)
+		}
+	}
+}
@@ -1,22 +1,153 @@
 package app
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
-// This file provides some utility functions for middleware.
-// You do not have to modify this file.
+// This file provides the middleware wrapped around the routes in Run, such as CORS, authentication and gzip.
 
-func simpleCORSMiddleware(next http.Handler, origin string, methods []string) http.Handler {
+// simpleCORSMiddleware allows cross-origin requests from the origins in the allowlist.
+// The request's Origin is echoed back only if it is allowed, and other origins get no CORS headers.
+// exposedHeaders are the response headers which browser JS may read besides the CORS-safelisted ones,
+// and browsers cache the result of a preflight for maxAge. Preflight OPTIONS requests never reach next.
+func simpleCORSMiddleware(next http.Handler, origins []string, methods []string, exposedHeaders []string, maxAge time.Duration) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", origin)
-		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ","))
-		w.Header().Set("Access-Control-Allow-Headers", "*")
+		//許可するオリジンはリクエストごとに変わるので、キャッシュにも伝える
+		w.Header().Add("Vary", "Origin")
+		if origin := r.Header.Get("Origin"); slices.Contains(origins, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ","))
+			//ワイルドカードにはAuthorizationが含まれないので、別に指定する
+			w.Header().Set("Access-Control-Allow-Headers", "*, Authorization")
+			//ブラウザのJSから読めるようにする
+			if len(exposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(exposedHeaders, ", "))
+			}
+		}
 
 		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+			//プリフライトの結果をキャッシュさせて、毎回OPTIONSを送らせないようにする
+			if w.Header().Get("Access-Control-Allow-Origin") != "" {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(maxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiKeyMiddleware requires the X-API-Key header to match key for requests which change items
+// (POST, PUT, PATCH and DELETE) and for the admin endpoints under /admin/ . Other requests are public.
+// If key is empty, authentication is disabled, which is handy for local development.
+func apiKeyMiddleware(next http.Handler, key string) http.Handler {
+	if key == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isReadOnlyMethod(r.Method) && !strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		//キーの比較にかかる時間から中身を推測されないようにする
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-API-Key")), []byte(key)) != 1 {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "a valid X-API-Key header is required")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isReadOnlyMethod reports whether requests with method don't change items.
+func isReadOnlyMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+type sellerIDKey struct{}
+
+// jwtMiddleware verifies the HS256 JWT in the "Authorization: Bearer" header and stores its sub claim
+// in the request context as the seller id. A token is required for requests which change items
+// and for the endpoints of the caller under /my/ . Other requests may be sent without one.
+// If secret is empty, authentication is disabled.
+func jwtMiddleware(next http.Handler, secret []byte) http.Handler {
+	if len(secret) == 0 {
+		return next
+	}
+
+	keyFunc := func(*jwt.Token) (any, error) { return secret, nil }
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			if isReadOnlyMethod(r.Method) && !strings.HasPrefix(r.URL.Path, "/my/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			writeError(w, http.StatusUnauthorized, "unauthorized", "a bearer token is required")
+			return
+		}
+
+		//アルゴリズムを固定して、alg=noneなどのトークンを受け付けないようにする
+		token, err := jwt.Parse(tokenString, keyFunc, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, "invalid_token", "the bearer token is invalid")
+			return
+		}
+		sub, err := token.Claims.GetSubject()
+		if err != nil || sub == "" {
+			writeError(w, http.StatusUnauthorized, "invalid_token", "the bearer token has no sub claim")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sellerIDKey{}, sub)))
+	})
+}
+
+// sellerIDFromContext returns the seller id set by jwtMiddleware, or "" if the request is not authenticated.
+func sellerIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(sellerIDKey{}).(string)
+	return id
+}
+
+// maxBodySizeMiddleware limits the body of every request to limit bytes, so that no request can exhaust memory.
+// Reading beyond the limit fails with *http.MaxBytesError, which handlers report as 413 Request Entity Too Large.
+func maxBodySizeMiddleware(next http.Handler, limit int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maxInFlightMiddleware handles at most limit requests at once and responds with 503 Service Unavailable
+// to requests beyond that, instead of letting them queue up while the server is overloaded.
+func maxInFlightMiddleware(next http.Handler, limit int) http.Handler {
+	sem := make(chan struct{}, limit)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+		default:
+			//空きがなければ待たずにすぐ断る
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusServiceUnavailable, "server_busy", "too many requests in progress")
 			return
 		}
 
@@ -24,9 +155,192 @@ func simpleCORSMiddleware(next http.Handler, origin string, methods []string) ht
 	})
 }
 
+// methodNotAllowedMiddleware replaces the plain text body of the 405 Method Not Allowed responses of ServeMux,
+// sent when a path exists but not for the method of the request, with an ErrorResponse.
+// The Allow header listing the methods of the path is set by ServeMux.
+func methodNotAllowedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&methodNotAllowedResponseWriter{ResponseWriter: w, method: r.Method}, r)
+	})
+}
+
+// methodNotAllowedResponseWriter writes an ErrorResponse instead of the body of a 405 response.
+type methodNotAllowedResponseWriter struct {
+	http.ResponseWriter
+	method      string
+	intercepted bool
+}
+
+func (w *methodNotAllowedResponseWriter) WriteHeader(status int) {
+	allow := w.Header().Get("Allow")
+	if status != http.StatusMethodNotAllowed || allow == "" || w.intercepted {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	w.intercepted = true
+	writeError(w.ResponseWriter, status, "method_not_allowed", fmt.Sprintf("method %s is not allowed, use one of %s", w.method, allow))
+}
+
+func (w *methodNotAllowedResponseWriter) Write(b []byte) (int, error) {
+	//ServeMuxが書くテキストの本文は捨てる
+	if w.intercepted {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the original ResponseWriter for http.ResponseController.
+func (w *methodNotAllowedResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func simpleLoggerMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		slog.Info("request received", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr, "user_agent", r.UserAgent())
+		slog.InfoContext(r.Context(), "request received", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr, "user_agent", r.UserAgent())
 		next.ServeHTTP(w, r)
 	})
 }
+
+type requestIDKey struct{}
+
+// requestIDMiddleware assigns a random id to each request to trace it across log lines.
+// The id is stored in the request context and returned in the X-Request-ID response header.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := make([]byte, 16)
+		rand.Read(b) // never returns an error
+		id := hex.EncodeToString(b)
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestIDFromContext returns the request id set by requestIDMiddleware, or "" if there is none.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDLogHandler is a slog.Handler which adds the request id in the context to each log record.
+// Use the slog functions taking a context (e.g. slog.ErrorContext) to include it.
+type requestIDLogHandler struct {
+	slog.Handler
+}
+
+func (h requestIDLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestIDFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDLogHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDLogHandler) WithGroup(name string) slog.Handler {
+	return requestIDLogHandler{h.Handler.WithGroup(name)}
+}
+
+// gzipMiddleware compresses responses with gzip if the client accepts it.
+// Images are already compressed, so responses with an image content type are sent as is.
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		if coding = strings.TrimSpace(coding); coding != "gzip" && coding != "*" {
+			continue
+		}
+		//q=0は「使わない」という意味
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(v, 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the body written to it unless the response shouldn't be compressed.
+// The decision is made when the header is written.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	compress := status != http.StatusNoContent && status != http.StatusNotModified &&
+		h.Get("Content-Encoding") == "" &&
+		!strings.HasPrefix(h.Get("Content-Type"), "image/")
+	if compress {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		//net/httpと同じように、Content-Typeがなければ中身から判定する
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the original ResponseWriter for http.ResponseController.
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// FlushError sends the data compressed so far to the client, for streamed responses.
+// It is called by http.ResponseController.Flush.
+func (w *gzipResponseWriter) FlushError() error {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		if err := w.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// close flushes the compressed body.
+func (w *gzipResponseWriter) close() {
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
@@ -0,0 +1,608 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	logger := slog.New(requestIDLogHandler{slog.NewJSONHandler(buf, nil)})
+
+	var gotID string
+	h := requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = requestIDFromContext(r.Context())
+		logger.InfoContext(r.Context(), "handled")
+	}))
+
+	ids := map[string]bool{}
+	for range 2 {
+		buf.Reset()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+		headerID := rr.Header().Get("X-Request-ID")
+		if headerID == "" {
+			t.Fatalf("expected X-Request-ID header to be set")
+		}
+		if gotID != headerID {
+			t.Errorf("expected request id in context %s, got %s", headerID, gotID)
+		}
+		ids[headerID] = true
+
+		var record map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+			t.Fatalf("failed to decode log record: %v", err)
+		}
+		if record["request_id"] != headerID {
+			t.Errorf("expected request_id %s in log, got %v", headerID, record["request_id"])
+		}
+	}
+
+	if len(ids) != 2 {
+		t.Errorf("expected a different id for each request, got %v", ids)
+	}
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	t.Parallel()
+
+	body := strings.Repeat(`{"name":"jacket","category":"fashion"}`, 100)
+
+	type wants struct {
+		gzipped bool
+	}
+	cases := map[string]struct {
+		acceptEncoding string
+		contentType    string
+		wants
+	}{
+		"ok: json is compressed": {
+			acceptEncoding: "gzip, deflate, br",
+			contentType:    "application/json",
+			wants:          wants{gzipped: true},
+		},
+		"ok: client without gzip": {
+			acceptEncoding: "deflate",
+			contentType:    "application/json",
+			wants:          wants{gzipped: false},
+		},
+		"ok: gzip refused with q=0": {
+			acceptEncoding: "gzip;q=0, deflate",
+			contentType:    "application/json",
+			wants:          wants{gzipped: false},
+		},
+		"ok: images are not compressed": {
+			acceptEncoding: "gzip",
+			contentType:    "image/jpeg",
+			wants:          wants{gzipped: false},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				w.Write([]byte(body))
+			}))
+
+			req := httptest.NewRequest("GET", "/items", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			gotGzipped := rr.Header().Get("Content-Encoding") == "gzip"
+			if gotGzipped != tt.wants.gzipped {
+				t.Fatalf("expected gzipped %v, got Content-Encoding %q", tt.wants.gzipped, rr.Header().Get("Content-Encoding"))
+			}
+			if got := rr.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("expected Vary Accept-Encoding, got %q", got)
+			}
+
+			got := rr.Body.Bytes()
+			if tt.wants.gzipped {
+				if rr.Header().Get("Content-Length") != "" {
+					t.Errorf("expected no Content-Length for a compressed body")
+				}
+				zr, err := gzip.NewReader(rr.Body)
+				if err != nil {
+					t.Fatalf("failed to read gzip body: %v", err)
+				}
+				if got, err = io.ReadAll(zr); err != nil {
+					t.Fatalf("failed to read gzip body: %v", err)
+				}
+			}
+			if string(got) != body {
+				t.Errorf("unexpected body: %s", got)
+			}
+		})
+	}
+}
+
+func TestGzipMiddlewareFlush(t *testing.T) {
+	t.Parallel()
+
+	rr := httptest.NewRecorder()
+	h := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte(`{"name":"jacket"}` + "\n"))
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("failed to flush: %v", err)
+		}
+
+		// the first line reaches the client before the handler returns
+		if !rr.Flushed {
+			t.Errorf("expected the response to be flushed")
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(rr.Body.Bytes()))
+		if err != nil {
+			t.Fatalf("failed to read gzip body: %v", err)
+		}
+		line := make([]byte, len(`{"name":"jacket"}`)+1)
+		if _, err := io.ReadFull(zr, line); err != nil {
+			t.Errorf("failed to read the flushed line: %v", err)
+		}
+	}))
+
+	req := httptest.NewRequest("GET", "/items.ndjson", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("expected Content-Encoding gzip, got %q", got)
+	}
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		key    string
+		method string
+		path   string
+		header string
+		wants
+	}{
+		"ok: get is public": {
+			key:    "secret",
+			method: "GET",
+			path:   "/items",
+			wants:  wants{code: http.StatusOK},
+		},
+		"ok: post with the key": {
+			key:    "secret",
+			method: "POST",
+			path:   "/items",
+			header: "secret",
+			wants:  wants{code: http.StatusOK},
+		},
+		"ok: auth disabled without a key": {
+			key:    "",
+			method: "DELETE",
+			path:   "/items/1",
+			wants:  wants{code: http.StatusOK},
+		},
+		"ng: post without the key": {
+			key:    "secret",
+			method: "POST",
+			path:   "/items",
+			wants:  wants{code: http.StatusUnauthorized},
+		},
+		"ng: put with a wrong key": {
+			key:    "secret",
+			method: "PUT",
+			path:   "/items/1",
+			header: "secrets",
+			wants:  wants{code: http.StatusUnauthorized},
+		},
+		"ng: delete without the key": {
+			key:    "secret",
+			method: "DELETE",
+			path:   "/items/1",
+			wants:  wants{code: http.StatusUnauthorized},
+		},
+		"ng: admin get without the key": {
+			key:    "secret",
+			method: "GET",
+			path:   "/admin/items",
+			wants:  wants{code: http.StatusUnauthorized},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := apiKeyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}), tt.key)
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("X-API-Key", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wants.code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+		})
+	}
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("secret")
+	sign := func(method jwt.SigningMethod, key any, claims jwt.MapClaims) string {
+		t.Helper()
+		s, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return s
+	}
+
+	type wants struct {
+		code     int
+		sellerID string
+	}
+	cases := map[string]struct {
+		secret []byte
+		method string
+		path   string
+		token  string
+		wants
+	}{
+		"ok: get is public": {
+			secret: secret,
+			method: "GET",
+			path:   "/items",
+			wants:  wants{code: http.StatusOK},
+		},
+		"ok: post with a token": {
+			secret: secret,
+			method: "POST",
+			path:   "/items",
+			token:  sign(jwt.SigningMethodHS256, secret, jwt.MapClaims{"sub": "seller1"}),
+			wants:  wants{code: http.StatusOK, sellerID: "seller1"},
+		},
+		"ok: get with a token": {
+			secret: secret,
+			method: "GET",
+			path:   "/my/items",
+			token:  sign(jwt.SigningMethodHS256, secret, jwt.MapClaims{"sub": "seller1"}),
+			wants:  wants{code: http.StatusOK, sellerID: "seller1"},
+		},
+		"ok: auth disabled without a secret": {
+			method: "DELETE",
+			path:   "/items/1",
+			wants:  wants{code: http.StatusOK},
+		},
+		"ng: post without a token": {
+			secret: secret,
+			method: "POST",
+			path:   "/items",
+			wants:  wants{code: http.StatusUnauthorized},
+		},
+		"ng: my items without a token": {
+			secret: secret,
+			method: "GET",
+			path:   "/my/items",
+			wants:  wants{code: http.StatusUnauthorized},
+		},
+		"ng: wrong secret": {
+			secret: secret,
+			method: "POST",
+			path:   "/items",
+			token:  sign(jwt.SigningMethodHS256, []byte("secrets"), jwt.MapClaims{"sub": "seller1"}),
+			wants:  wants{code: http.StatusUnauthorized},
+		},
+		"ng: other algorithm": {
+			secret: secret,
+			method: "POST",
+			path:   "/items",
+			token:  sign(jwt.SigningMethodHS512, secret, jwt.MapClaims{"sub": "seller1"}),
+			wants:  wants{code: http.StatusUnauthorized},
+		},
+		"ng: unsigned token": {
+			secret: secret,
+			method: "POST",
+			path:   "/items",
+			token:  sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, jwt.MapClaims{"sub": "seller1"}),
+			wants:  wants{code: http.StatusUnauthorized},
+		},
+		"ng: expired token": {
+			secret: secret,
+			method: "POST",
+			path:   "/items",
+			token:  sign(jwt.SigningMethodHS256, secret, jwt.MapClaims{"sub": "seller1", "exp": time.Now().Add(-time.Hour).Unix()}),
+			wants:  wants{code: http.StatusUnauthorized},
+		},
+		"ng: no sub claim": {
+			secret: secret,
+			method: "POST",
+			path:   "/items",
+			token:  sign(jwt.SigningMethodHS256, secret, jwt.MapClaims{}),
+			wants:  wants{code: http.StatusUnauthorized},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var sellerID string
+			h := jwtMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sellerID = sellerIDFromContext(r.Context())
+				w.WriteHeader(http.StatusOK)
+			}), tt.secret)
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wants.code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if sellerID != tt.wants.sellerID {
+				t.Errorf("expected seller id %q, got %q", tt.wants.sellerID, sellerID)
+			}
+		})
+	}
+}
+
+func TestMaxInFlightMiddleware(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := maxInFlightMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}), 1)
+
+	// the first request takes the only slot until it is released
+	done := make(chan int)
+	go func() {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/items", nil))
+		done <- rr.Code
+	}()
+	<-started
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/items", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status code %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got == "" {
+		t.Error("expected a Retry-After header")
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("expected status code %d for the first request, got %d", http.StatusOK, code)
+	}
+
+	// the slot is free again
+	go func() { <-started }()
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/items", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status code %d after the first request, got %d", http.StatusOK, rr.Code)
+	}
+}
+
+func TestMethodNotAllowedMiddleware(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	mux.HandleFunc("GET /{$}", ok)
+	mux.HandleFunc("GET /items", ok)
+	mux.HandleFunc("POST /items", ok)
+	mux.HandleFunc("GET /items/{id}", ok)
+	mux.HandleFunc("PUT /items/{id}", ok)
+	mux.HandleFunc("DELETE /items/{id}", ok)
+	h := methodNotAllowedMiddleware(mux)
+
+	type wants struct {
+		code  int
+		allow string
+	}
+	cases := map[string]struct {
+		method string
+		path   string
+		wants
+	}{
+		"ok: supported method": {
+			method: "PUT",
+			path:   "/items/1",
+			wants:  wants{code: http.StatusOK},
+		},
+		"ng: post to an item": {
+			method: "POST",
+			path:   "/items/1",
+			wants:  wants{code: http.StatusMethodNotAllowed, allow: "DELETE, GET, HEAD, PUT"},
+		},
+		"ng: delete all items": {
+			method: "DELETE",
+			path:   "/items",
+			wants:  wants{code: http.StatusMethodNotAllowed, allow: "GET, HEAD, POST"},
+		},
+		"ng: unknown path": {
+			method: "DELETE",
+			path:   "/unknown",
+			wants:  wants{code: http.StatusNotFound},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if rr.Code != tt.wants.code {
+				t.Fatalf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if got := rr.Header().Get("Allow"); got != tt.wants.allow {
+				t.Errorf("expected Allow %q, got %q", tt.wants.allow, got)
+			}
+			if tt.wants.code != http.StatusMethodNotAllowed {
+				return
+			}
+			var got ErrorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode error response: %v", err)
+			}
+			if got.Code != "method_not_allowed" {
+				t.Errorf("expected error code method_not_allowed, got %s", got.Code)
+			}
+		})
+	}
+}
+
+func TestSimpleCORSMiddleware(t *testing.T) {
+	t.Parallel()
+
+	origins := parseOrigins("http://localhost:3000, https://staging.example.com/,")
+
+	exposedHeaders := []string{"Location", "X-Request-ID", "X-Total-Count"}
+
+	cases := map[string]struct {
+		origin     string
+		wantOrigin string
+	}{
+		"ok: first origin":     {origin: "http://localhost:3000", wantOrigin: "http://localhost:3000"},
+		"ok: another origin":   {origin: "https://staging.example.com", wantOrigin: "https://staging.example.com"},
+		"ng: unknown origin":   {origin: "https://evil.example.com", wantOrigin: ""},
+		"ng: no origin header": {origin: "", wantOrigin: ""},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := simpleCORSMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), origins, []string{"GET"}, exposedHeaders, 10*time.Minute)
+
+			req := httptest.NewRequest("GET", "/items", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("expected Access-Control-Allow-Origin %q, got %q", tt.wantOrigin, got)
+			}
+			if tt.wantOrigin == "" && rr.Header().Get("Access-Control-Allow-Methods") != "" {
+				t.Errorf("expected no CORS headers for origin %q", tt.origin)
+			}
+			wantExposed := ""
+			if tt.wantOrigin != "" {
+				wantExposed = "Location, X-Request-ID, X-Total-Count"
+			}
+			if got := rr.Header().Get("Access-Control-Expose-Headers"); got != wantExposed {
+				t.Errorf("expected Access-Control-Expose-Headers %q, got %q", wantExposed, got)
+			}
+			if got := rr.Header().Get("Vary"); got != "Origin" {
+				t.Errorf("expected Vary Origin, got %q", got)
+			}
+		})
+	}
+}
+
+func TestSimpleCORSMiddlewarePreflight(t *testing.T) {
+	t.Parallel()
+
+	origins := []string{"http://localhost:3000"}
+
+	type wants struct {
+		code       int
+		maxAge     string
+		nextCalled bool
+	}
+	cases := map[string]struct {
+		method string
+		origin string
+		wants
+	}{
+		"ok: preflight is cached": {
+			method: "OPTIONS",
+			origin: "http://localhost:3000",
+			wants: wants{
+				code:   http.StatusNoContent,
+				maxAge: "600",
+			},
+		},
+		"ok: preflight from unknown origin": {
+			method: "OPTIONS",
+			origin: "https://evil.example.com",
+			wants: wants{
+				code: http.StatusNoContent,
+			},
+		},
+		"ok: other methods reach the handler": {
+			method: "GET",
+			origin: "http://localhost:3000",
+			wants: wants{
+				code:       http.StatusOK,
+				nextCalled: true,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			nextCalled := false
+			h := simpleCORSMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+			}), origins, []string{"GET", "OPTIONS"}, nil, 600*time.Second)
+
+			req := httptest.NewRequest(tt.method, "/items", nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", "POST")
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wants.code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if got := rr.Header().Get("Access-Control-Max-Age"); got != tt.wants.maxAge {
+				t.Errorf("expected Access-Control-Max-Age %q, got %q", tt.wants.maxAge, got)
+			}
+			if nextCalled != tt.wants.nextCalled {
+				t.Errorf("expected the handler called: %v, got %v", tt.wants.nextCalled, nextCalled)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// legacyItem is an item in the items.json written by older training branches (see document/04-api).
+type legacyItem struct {
+	Name      string `json:"name"`
+	Category  string `json:"category"`
+	ImageName string `json:"image_name"`
+	Image     string `json:"image"` // some branches used the same key as the current store
+	Price     int    `json:"price"`
+}
+
+// MigrateResult summarises a migration of legacy items.
+type MigrateResult struct {
+	Inserted int
+	Skipped  int
+}
+
+// MigrateLegacyItems inserts the items of a legacy items.json into repo.
+// Items which already exist or have no name or category are skipped, so it is safe to run it again.
+func MigrateLegacyItems(ctx context.Context, r io.Reader, repo ItemRepository) (MigrateResult, error) {
+	var data struct {
+		Items []legacyItem `json:"items"`
+	}
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return MigrateResult{}, fmt.Errorf("failed to decode legacy items: %w", err)
+	}
+
+	var result MigrateResult
+	for idx, legacy := range data.Items {
+		if legacy.Name == "" || legacy.Category == "" {
+			slog.WarnContext(ctx, "skipped legacy item without name or category", "index", idx)
+			result.Skipped++
+			continue
+		}
+
+		item := &Item{
+			Name:      legacy.Name,
+			Category:  legacy.Category,
+			ImageName: legacy.ImageName,
+			Price:     legacy.Price,
+			Stock:     defaultStock,
+		}
+		if item.ImageName == "" {
+			item.ImageName = legacy.Image
+		}
+
+		//すでに移行済みのitemは飛ばす
+		if _, err := repo.Insert(ctx, item); err != nil {
+			if errors.Is(err, errDuplicateItem) {
+				result.Skipped++
+				continue
+			}
+			return result, fmt.Errorf("failed to insert legacy item %d: %w", idx, err)
+		}
+		result.Inserted++
+	}
+
+	return result, nil
+}
+
+// Migrate is the migrate subcommand. It inserts the items of the legacy items.json at args[0]
+// into the repository configured by the environment (see NewItemRepositoryFromEnv), brings the repository
+// to the current layout with MigrateSchema and prints a summary.
+// It refuses to run when args[0] is the JSON file of the repository itself, which is items.json by default too.
+func Migrate(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: migrate <legacy items.json>")
+		return 2
+	}
+
+	itemRepo, err := NewItemRepositoryFromEnv()
+	if err != nil {
+		slog.Error("failed to set up item repository: ", "error", err)
+		return 1
+	}
+	//移行元と移行先が同じファイルだと、読みながら上書きしてしまう
+	if repo, ok := itemRepo.(*itemRepository); ok && repo.fileName != "" && isSameFile(args[0], repo.fileName) {
+		slog.Error("legacy items must not be the repository file; set DB_PATH to another file", "path", args[0])
+		return 1
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		slog.Error("failed to open legacy items: ", "error", err)
+		return 1
+	}
+	defer f.Close()
+
+	ctx := context.Background()
+	result, err := MigrateLegacyItems(ctx, f, itemRepo)
+	fmt.Printf("inserted %d items, skipped %d items\n", result.Inserted, result.Skipped)
+	if err != nil {
+		slog.Error("failed to migrate legacy items: ", "error", err)
+		return 1
+	}
+	if err := itemRepo.MigrateSchema(ctx); err != nil {
+		slog.Error("failed to migrate schema: ", "error", err)
+		return 1
+	}
+
+	return 0
+}
+
+// isSameFile reports whether the paths a and b refer to the same existing file.
+func isSameFile(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(aInfo, bInfo)
+}
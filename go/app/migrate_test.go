@@ -0,0 +1,115 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMigrateLegacyItems(t *testing.T) {
+	t.Parallel()
+
+	legacy := `{"items": [
+		{"name": "jacket", "category": "fashion", "image_name": "510824df.jpg"},
+		{"name": "iPhone", "category": "phone", "image": "a1b2c3d4.jpg"},
+		{"name": "jacket", "category": "fashion", "image_name": "510824df.jpg"},
+		{"name": "", "category": "fashion"}
+	]}`
+
+	ctx := context.Background()
+	repo := NewMemoryItemRepository()
+
+	result, err := MigrateLegacyItems(ctx, strings.NewReader(legacy), repo)
+	if err != nil {
+		t.Fatalf("failed to migrate legacy items: %v", err)
+	}
+	if diff := cmp.Diff(MigrateResult{Inserted: 2, Skipped: 2}, result); diff != "" {
+		t.Errorf("unexpected result (-want +got):\n%s", diff)
+	}
+
+	items, err := repo.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	got := map[string]string{}
+	for _, item := range items {
+		got[item.Name] = item.ImageName
+	}
+	want := map[string]string{"jacket": "510824df.jpg", "iPhone": "a1b2c3d4.jpg"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected items (-want +got):\n%s", diff)
+	}
+
+	// running it again inserts nothing
+	result, err = MigrateLegacyItems(ctx, strings.NewReader(legacy), repo)
+	if err != nil {
+		t.Fatalf("failed to migrate legacy items: %v", err)
+	}
+	if diff := cmp.Diff(MigrateResult{Inserted: 0, Skipped: 4}, result); diff != "" {
+		t.Errorf("unexpected result of the second run (-want +got):\n%s", diff)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	// not parallel because it sets environment variables
+
+	t.Run("ok: the repository is brought to the current layout", func(t *testing.T) {
+		dir := t.TempDir()
+		legacyPath := filepath.Join(dir, "legacy.json")
+		if err := os.WriteFile(legacyPath, []byte(`{"items": []}`), 0644); err != nil {
+			t.Fatalf("failed to write legacy items: %v", err)
+		}
+		dbPath := filepath.Join(dir, "items.json")
+		if err := os.WriteFile(dbPath, []byte(`{"items":[{"name":"jacket","category":"fashion"}]}`), 0644); err != nil {
+			t.Fatalf("failed to write items: %v", err)
+		}
+		t.Setenv("REPO_BACKEND", "file")
+		t.Setenv("DB_PATH", dbPath)
+
+		if code := Migrate([]string{legacyPath}); code != 0 {
+			t.Fatalf("expected exit code 0, got %d", code)
+		}
+
+		dataBytes, err := os.ReadFile(dbPath)
+		if err != nil {
+			t.Fatalf("failed to read items: %v", err)
+		}
+		var data struct {
+			Version int `json:"version"`
+		}
+		if err := json.Unmarshal(dataBytes, &data); err != nil {
+			t.Fatalf("failed to decode items: %v", err)
+		}
+		if data.Version != len(itemMigrations) {
+			t.Errorf("expected version %d, got %d", len(itemMigrations), data.Version)
+		}
+	})
+
+	t.Run("ng: the legacy file is the repository file", func(t *testing.T) {
+		dir := t.TempDir()
+		legacy := `{"items": [{"name": "jacket", "category": "fashion"}]}`
+		legacyPath := filepath.Join(dir, "items.json")
+		if err := os.WriteFile(legacyPath, []byte(legacy), 0644); err != nil {
+			t.Fatalf("failed to write legacy items: %v", err)
+		}
+		t.Setenv("REPO_BACKEND", "file")
+		t.Setenv("DB_PATH", legacyPath)
+
+		if code := Migrate([]string{legacyPath}); code != 1 {
+			t.Fatalf("expected exit code 1, got %d", code)
+		}
+
+		dataBytes, err := os.ReadFile(legacyPath)
+		if err != nil {
+			t.Fatalf("failed to read legacy items: %v", err)
+		}
+		if diff := cmp.Diff(legacy, string(dataBytes)); diff != "" {
+			t.Errorf("expected the legacy file to be unchanged (-want +got):\n%s", diff)
+		}
+	})
+}
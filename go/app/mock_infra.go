@@ -40,16 +40,300 @@ func (m *MockItemRepository) EXPECT() *MockItemRepositoryMockRecorder {
 	return m.recorder
 }
 
-// Insert mocks base method.
-func (m *MockItemRepository) Insert(ctx context.Context, item *Item) error {
+// Count mocks base method.
+func (m *MockItemRepository) Count(ctx context.Context) (int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Insert", ctx, item)
+	ret := m.ctrl.Call(m, "Count", ctx)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Count indicates an expected call of Count.
+func (mr *MockItemRepositoryMockRecorder) Count(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Count", reflect.TypeOf((*MockItemRepository)(nil).Count), ctx)
+}
+
+// Delete mocks base method.
+func (m *MockItemRepository) Delete(ctx context.Context, id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, id)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
+// Delete indicates an expected call of Delete.
+func (mr *MockItemRepositoryMockRecorder) Delete(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockItemRepository)(nil).Delete), ctx, id)
+}
+
+// Insert mocks base method.
+func (m *MockItemRepository) Insert(ctx context.Context, item *Item) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Insert", ctx, item)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
 // Insert indicates an expected call of Insert.
 func (mr *MockItemRepositoryMockRecorder) Insert(ctx, item any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Insert", reflect.TypeOf((*MockItemRepository)(nil).Insert), ctx, item)
 }
+
+// InsertBatch mocks base method.
+func (m *MockItemRepository) InsertBatch(ctx context.Context, items []*Item) ([]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InsertBatch", ctx, items)
+	ret0, _ := ret[0].([]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InsertBatch indicates an expected call of InsertBatch.
+func (mr *MockItemRepositoryMockRecorder) InsertBatch(ctx, items any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertBatch", reflect.TypeOf((*MockItemRepository)(nil).InsertBatch), ctx, items)
+}
+
+// List mocks base method.
+func (m *MockItemRepository) List(ctx context.Context) ([]*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx)
+	ret0, _ := ret[0].([]*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockItemRepositoryMockRecorder) List(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockItemRepository)(nil).List), ctx)
+}
+
+// ListAll mocks base method.
+func (m *MockItemRepository) ListAll(ctx context.Context) ([]*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAll", ctx)
+	ret0, _ := ret[0].([]*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAll indicates an expected call of ListAll.
+func (mr *MockItemRepositoryMockRecorder) ListAll(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAll", reflect.TypeOf((*MockItemRepository)(nil).ListAll), ctx)
+}
+
+// ListByCategory mocks base method.
+func (m *MockItemRepository) ListByCategory(ctx context.Context, category string) ([]*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListByCategory", ctx, category)
+	ret0, _ := ret[0].([]*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListByCategory indicates an expected call of ListByCategory.
+func (mr *MockItemRepositoryMockRecorder) ListByCategory(ctx, category any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListByCategory", reflect.TypeOf((*MockItemRepository)(nil).ListByCategory), ctx, category)
+}
+
+// ListBySeller mocks base method.
+func (m *MockItemRepository) ListBySeller(ctx context.Context, sellerID string) ([]*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListBySeller", ctx, sellerID)
+	ret0, _ := ret[0].([]*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListBySeller indicates an expected call of ListBySeller.
+func (mr *MockItemRepositoryMockRecorder) ListBySeller(ctx, sellerID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListBySeller", reflect.TypeOf((*MockItemRepository)(nil).ListBySeller), ctx, sellerID)
+}
+
+// ListCategories mocks base method.
+func (m *MockItemRepository) ListCategories(ctx context.Context) ([]*Category, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListCategories", ctx)
+	ret0, _ := ret[0].([]*Category)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListCategories indicates an expected call of ListCategories.
+func (mr *MockItemRepositoryMockRecorder) ListCategories(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListCategories", reflect.TypeOf((*MockItemRepository)(nil).ListCategories), ctx)
+}
+
+// ListSimilar mocks base method.
+func (m *MockItemRepository) ListSimilar(ctx context.Context, id, limit int) ([]*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListSimilar", ctx, id, limit)
+	ret0, _ := ret[0].([]*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListSimilar indicates an expected call of ListSimilar.
+func (mr *MockItemRepositoryMockRecorder) ListSimilar(ctx, id, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListSimilar", reflect.TypeOf((*MockItemRepository)(nil).ListSimilar), ctx, id, limit)
+}
+
+// MigrateSchema mocks base method.
+func (m *MockItemRepository) MigrateSchema(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MigrateSchema", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MigrateSchema indicates an expected call of MigrateSchema.
+func (mr *MockItemRepositoryMockRecorder) MigrateSchema(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MigrateSchema", reflect.TypeOf((*MockItemRepository)(nil).MigrateSchema), ctx)
+}
+
+// Purchase mocks base method.
+func (m *MockItemRepository) Purchase(ctx context.Context, id int) (*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Purchase", ctx, id)
+	ret0, _ := ret[0].(*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Purchase indicates an expected call of Purchase.
+func (mr *MockItemRepositoryMockRecorder) Purchase(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Purchase", reflect.TypeOf((*MockItemRepository)(nil).Purchase), ctx, id)
+}
+
+// ReassignCategory mocks base method.
+func (m *MockItemRepository) ReassignCategory(ctx context.Context, from, into string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReassignCategory", ctx, from, into)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReassignCategory indicates an expected call of ReassignCategory.
+func (mr *MockItemRepositoryMockRecorder) ReassignCategory(ctx, from, into any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReassignCategory", reflect.TypeOf((*MockItemRepository)(nil).ReassignCategory), ctx, from, into)
+}
+
+// Restore mocks base method.
+func (m *MockItemRepository) Restore(ctx context.Context, id int) (*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Restore", ctx, id)
+	ret0, _ := ret[0].(*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Restore indicates an expected call of Restore.
+func (mr *MockItemRepositoryMockRecorder) Restore(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Restore", reflect.TypeOf((*MockItemRepository)(nil).Restore), ctx, id)
+}
+
+// Search mocks base method.
+func (m *MockItemRepository) Search(ctx context.Context, keyword string, mode searchMode) ([]*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Search", ctx, keyword, mode)
+	ret0, _ := ret[0].([]*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Search indicates an expected call of Search.
+func (mr *MockItemRepositoryMockRecorder) Search(ctx, keyword, mode any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Search", reflect.TypeOf((*MockItemRepository)(nil).Search), ctx, keyword, mode)
+}
+
+// SearchPage mocks base method.
+func (m *MockItemRepository) SearchPage(ctx context.Context, keyword string, mode searchMode, limit, offset int) ([]*Item, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchPage", ctx, keyword, mode, limit, offset)
+	ret0, _ := ret[0].([]*Item)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// SearchPage indicates an expected call of SearchPage.
+func (mr *MockItemRepositoryMockRecorder) SearchPage(ctx, keyword, mode, limit, offset any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchPage", reflect.TypeOf((*MockItemRepository)(nil).SearchPage), ctx, keyword, mode, limit, offset)
+}
+
+// Select mocks base method.
+func (m *MockItemRepository) Select(ctx context.Context, id int) (*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Select", ctx, id)
+	ret0, _ := ret[0].(*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Select indicates an expected call of Select.
+func (mr *MockItemRepositoryMockRecorder) Select(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Select", reflect.TypeOf((*MockItemRepository)(nil).Select), ctx, id)
+}
+
+// SelectRandom mocks base method.
+func (m *MockItemRepository) SelectRandom(ctx context.Context) (*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SelectRandom", ctx)
+	ret0, _ := ret[0].(*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SelectRandom indicates an expected call of SelectRandom.
+func (mr *MockItemRepositoryMockRecorder) SelectRandom(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SelectRandom", reflect.TypeOf((*MockItemRepository)(nil).SelectRandom), ctx)
+}
+
+// Suggest mocks base method.
+func (m *MockItemRepository) Suggest(ctx context.Context, prefix string, limit int) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Suggest", ctx, prefix, limit)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Suggest indicates an expected call of Suggest.
+func (mr *MockItemRepositoryMockRecorder) Suggest(ctx, prefix, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Suggest", reflect.TypeOf((*MockItemRepository)(nil).Suggest), ctx, prefix, limit)
+}
+
+// Update mocks base method.
+func (m *MockItemRepository) Update(ctx context.Context, item *Item) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", ctx, item)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockItemRepositoryMockRecorder) Update(ctx, item any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockItemRepository)(nil).Update), ctx, item)
+}
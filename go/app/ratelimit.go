@@ -0,0 +1,100 @@
+package app
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// default rate limit per client IP, which can be overridden by RATE_LIMIT and RATE_LIMIT_BURST.
+const (
+	defaultRateLimit      = 10 // requests per second
+	defaultRateLimitBurst = 20
+)
+
+// rateLimiterIdleTTL is how long the bucket of a client is kept after its last request.
+// A forgotten client starts again with a full bucket, which is what it would have by then anyway.
+const rateLimiterIdleTTL = 3 * time.Minute
+
+// ipRateLimiter keeps a token bucket for each client IP.
+type ipRateLimiter struct {
+	mu        sync.Mutex
+	limit     rate.Limit
+	burst     int
+	clients   map[string]*rateLimiterEntry
+	lastSweep time.Time
+	// now returns the current time. It is replaced in tests.
+	now func() time.Time
+}
+
+type rateLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newIPRateLimiter allows each client perSecond requests per second on average and up to burst at once.
+func newIPRateLimiter(perSecond, burst int) *ipRateLimiter {
+	return &ipRateLimiter{
+		limit:   rate.Limit(perSecond),
+		burst:   burst,
+		clients: map[string]*rateLimiterEntry{},
+		now:     time.Now,
+	}
+}
+
+// reserve takes a token for ip and returns 0, or how long the client must wait if there is none left.
+func (l *ipRateLimiter) reserve(ip string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	//使われなくなったクライアントを定期的に削除する
+	if now.Sub(l.lastSweep) > rateLimiterIdleTTL {
+		for k, e := range l.clients {
+			if now.Sub(e.lastSeen) > rateLimiterIdleTTL {
+				delete(l.clients, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	e, ok := l.clients[ip]
+	if !ok {
+		e = &rateLimiterEntry{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[ip] = e
+	}
+	e.lastSeen = now
+
+	r := e.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		//拒否したリクエストではトークンを消費しない
+		r.CancelAt(now)
+		return delay
+	}
+	return 0
+}
+
+// rateLimitMiddleware responds with 429 Too Many Requests and a Retry-After header
+// when a client sends requests faster than l allows.
+// Clients are identified by the remote address; X-Forwarded-For is not trusted since anyone can set it.
+func rateLimitMiddleware(next http.Handler, l *ipRateLimiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		if delay := l.reserve(ip); delay > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "rate_limited", "too many requests")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
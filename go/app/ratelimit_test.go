@@ -0,0 +1,52 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	l := newIPRateLimiter(1, 2)
+	l.now = func() time.Time { return now }
+
+	h := rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), l)
+	send := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/items", nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// the burst is allowed, and the next request exceeds the limit
+	for i := range 2 {
+		if rr := send("192.0.2.1:1234"); rr.Code != http.StatusOK {
+			t.Fatalf("request %d: expected status code %d, got %d", i, http.StatusOK, rr.Code)
+		}
+	}
+	rr := send("192.0.2.1:5678")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status code %d, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("expected Retry-After 1, got %q", got)
+	}
+
+	// other clients have their own buckets
+	if rr := send("192.0.2.2:1234"); rr.Code != http.StatusOK {
+		t.Errorf("expected status code %d for another client, got %d", http.StatusOK, rr.Code)
+	}
+
+	// a token is added every second
+	now = now.Add(time.Second)
+	if rr := send("192.0.2.1:1234"); rr.Code != http.StatusOK {
+		t.Errorf("expected status code %d after waiting, got %d", http.StatusOK, rr.Code)
+	}
+}
@@ -1,18 +1,38 @@
 package app
 
 import (
+	"bytes"
+	"cmp"
+	"context"
 	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"image"
 	"io"
 	"log/slog"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
 )
 
 type Server struct {
@@ -22,224 +42,2632 @@ type Server struct {
 	ImageDirPath string
 }
 
+// shutdownTimeout is how long Run waits for in-flight requests on shutdown.
+const shutdownTimeout = 10 * time.Second
+
+// default timeouts of the HTTP server, which can be overridden by environment variables.
+const (
+	defaultReadTimeout  = 10 * time.Second
+	defaultWriteTimeout = 30 * time.Second
+	defaultIdleTimeout  = 60 * time.Second
+)
+
+// defaultMaxInFlight is the default number of requests handled at once.
+const defaultMaxInFlight = 100
+
+// defaultCORSMaxAge is the default time browsers cache the result of a preflight request.
+const defaultCORSMaxAge = 600 * time.Second
+
 // Run is a method to start the server. //Run→サーバーをスタート。戻り値0なら成功、1なら失敗
 // This method returns 0 if the server started successfully, and 1 otherwise.
+// On SIGINT or SIGTERM, it stops accepting new requests and waits for in-flight ones before returning.
 func (s Server) Run() int {
 	// set up logger //ログの設定
-	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
-	slog.SetDefault(logger)
 	// STEP 4-6: set the log level to DEBUG
-	slog.SetLogLoggerLevel(slog.LevelInfo)
+	logLevel, err := parseLogLevel(os.Getenv("LOG_LEVEL"))
+	logger := slog.New(requestIDLogHandler{slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})})
+	slog.SetDefault(logger)
+	if err != nil {
+		slog.Error("failed to set up logger: ", "error", err)
+		return 1
+	}
 
 	// set up CORS settings
+	// FRONT_URL is a comma-separated list of the origins allowed by CORS
 	frontURL, found := os.LookupEnv("FRONT_URL")
 	if !found {
 		frontURL = "http://localhost:3000"
 	}
+	allowedOrigins := parseOrigins(frontURL)
+
+	// STEP 5-1: set up the database connection
+	itemRepo, err := NewItemRepositoryFromEnv()
+	if err != nil {
+		slog.Error("failed to set up item repository: ", "error", err)
+		return 1
+	}
+	if err := itemRepo.MigrateSchema(context.Background()); err != nil {
+		slog.Error("failed to migrate item repository: ", "error", err)
+		return 1
+	}
+
+	// set up image settings
+	maxImageSize, err := lookupEnvInt("MAX_IMAGE_SIZE", defaultMaxImageSize)
+	if err != nil {
+		slog.Error("failed to read image settings: ", "error", err)
+		return 1
+	}
+	maxUploadSize, err := lookupEnvInt("MAX_UPLOAD_SIZE", defaultMaxUploadSize)
+	if err != nil {
+		slog.Error("failed to read image settings: ", "error", err)
+		return 1
+	}
+	// MAX_BODY_SIZE is the maximum size of any request body, which must leave room for an image of MAX_UPLOAD_SIZE
+	maxBodySize, err := lookupEnvInt("MAX_BODY_SIZE", defaultMaxBodySize)
+	if err != nil {
+		slog.Error("failed to read image settings: ", "error", err)
+		return 1
+	}
+
+	// MAX_MULTIPART_MEMORY is the part of an uploaded form kept in memory, and the rest is written to temporary files
+	maxMultipartMemory, err := lookupEnvInt("MAX_MULTIPART_MEMORY", defaultMultipartMemory)
+	if err != nil {
+		slog.Error("failed to read image settings: ", "error", err)
+		return 1
+	}
+	// MIN_FREE_DISK_SPACE is the free space in bytes below which GET /healthz reports the image directory as degraded
+	minFreeDiskSpace, err := lookupEnvInt("MIN_FREE_DISK_SPACE", defaultMinFreeDiskSpace)
+	if err != nil {
+		slog.Error("failed to read image settings: ", "error", err)
+		return 1
+	}
+
+	// DEFAULT_IMAGE is the image in the image directory returned for missing images
+	defaultImage, found := os.LookupEnv("DEFAULT_IMAGE")
+	if !found {
+		defaultImage = defaultImageName
+	}
+	if _, err := os.Stat(filepath.Join(s.ImageDirPath, defaultImage)); err != nil {
+		slog.Warn("default image is not available: ", "path", filepath.Join(s.ImageDirPath, defaultImage), "error", err)
+	}
+
+	// set up handlers
+	h := &Handlers{
+		imgDirPath:         s.ImageDirPath,
+		defaultImage:       defaultImage,
+		maxImageSize:       maxImageSize,
+		maxUploadSize:      int64(maxUploadSize),
+		maxMultipartMemory: int64(maxMultipartMemory),
+		minFreeDiskSpace:   uint64(minFreeDiskSpace),
+		idempotencyKeys:    newIdempotencyStore(idempotencyKeyTTL),
+		itemRepo:           itemRepo,
+	}
+
+	// API_KEY is required in the X-API-Key header of requests which change items. Authentication is disabled if it is not set.
+	apiKey := os.Getenv("API_KEY")
+	if apiKey == "" {
+		slog.Warn("API_KEY is not set, so anyone can change items")
+	}
+	// JWT_SECRET is the HS256 key of the bearer tokens identifying sellers. Authentication is disabled if it is not set.
+	jwtSecret := []byte(os.Getenv("JWT_SECRET"))
+	if len(jwtSecret) == 0 {
+		slog.Warn("JWT_SECRET is not set, so items are added without a seller")
+	}
+
+	// RATE_LIMIT is the number of requests per second allowed for each client IP, and RATE_LIMIT_BURST is the number allowed at once
+	rateLimit, err := lookupEnvInt("RATE_LIMIT", defaultRateLimit)
+	if err != nil {
+		slog.Error("failed to read rate limit settings: ", "error", err)
+		return 1
+	}
+	rateLimitBurst, err := lookupEnvInt("RATE_LIMIT_BURST", defaultRateLimitBurst)
+	if err != nil {
+		slog.Error("failed to read rate limit settings: ", "error", err)
+		return 1
+	}
+	limiter := newIPRateLimiter(rateLimit, rateLimitBurst)
+	// MAX_IN_FLIGHT is the number of requests handled at once
+	maxInFlight, err := lookupEnvInt("MAX_IN_FLIGHT", defaultMaxInFlight)
+	if err != nil {
+		slog.Error("failed to read server settings: ", "error", err)
+		return 1
+	}
+
+	// set up metrics
+	reg := prometheus.NewRegistry()
+	m := newMetrics(reg)
+
+	// set up routes
+	mux := http.NewServeMux()
+	//"GET /"だとすべてのパスに一致してしまうので、"/"だけに一致させる
+	mux.HandleFunc("GET /{$}", h.Hello)
+	mux.HandleFunc("GET /version", h.Version)
+	mux.HandleFunc("GET /healthz", h.Healthz)
+	mux.Handle("GET /metrics", metricsHandler(reg))
+	mux.HandleFunc("GET /items", h.GetItem)
+	mux.HandleFunc("GET /items/count", h.CountItems)
+	mux.HandleFunc("GET /items/random", h.RandomItem)
+	mux.HandleFunc("GET /items/categories", h.GetCategoryNames)
+	mux.HandleFunc("GET /items.csv", h.ExportItemsCSV)
+	mux.HandleFunc("GET /items.ndjson", h.ExportItemsNDJSON)
+	mux.HandleFunc("GET /items/{id}", h.GetAnItem)
+	mux.HandleFunc("GET /items/{id}/similar", h.GetSimilarItems)
+	mux.HandleFunc("GET /items/{id}/image", h.GetItemImage)
+	mux.HandleFunc("GET /search", h.Search)
+	mux.HandleFunc("GET /suggest", h.Suggest)
+	mux.HandleFunc("GET /categories", h.GetCategories)
+	mux.HandleFunc("GET /categories/{name}/items", h.GetCategoryItems)
+	mux.HandleFunc("DELETE /categories/{name}", h.DeleteCategory)
+	mux.HandleFunc("POST /categories/merge", h.MergeCategories)
+	mux.HandleFunc("GET /stats", h.Stats)
+	mux.HandleFunc("POST /items", h.AddItem)
+	mux.HandleFunc("POST /items/bulk", h.BulkAddItems)
+	mux.HandleFunc("POST /items/import", h.ImportItems)
+	mux.HandleFunc("PUT /items/{id}", h.UpdateItem)
+	mux.HandleFunc("POST /items/{id}/purchase", h.PurchaseItem)
+	mux.HandleFunc("POST /items/{id}/restore", h.RestoreItem)
+	mux.HandleFunc("DELETE /items/{id}", h.DeleteItem)
+	mux.HandleFunc("GET /images/{filename}", h.GetImage)
+	mux.HandleFunc("GET /admin/items", h.AdminGetItems)
+	mux.HandleFunc("GET /my/items", h.MyItems)
+	mux.HandleFunc("GET /images/{filename}/thumb", h.GetThumbnail)
+
+	// set up server timeouts to avoid slow clients holding connections forever
+	readTimeout, err := lookupEnvDuration("HTTP_READ_TIMEOUT", defaultReadTimeout)
+	if err != nil {
+		slog.Error("failed to read server settings: ", "error", err)
+		return 1
+	}
+	writeTimeout, err := lookupEnvDuration("HTTP_WRITE_TIMEOUT", defaultWriteTimeout)
+	if err != nil {
+		slog.Error("failed to read server settings: ", "error", err)
+		return 1
+	}
+	idleTimeout, err := lookupEnvDuration("HTTP_IDLE_TIMEOUT", defaultIdleTimeout)
+	if err != nil {
+		slog.Error("failed to read server settings: ", "error", err)
+		return 1
+	}
+
+	// custom response headers the frontend reads, which browsers hide from JS unless they are exposed
+	exposedHeaders := []string{"ETag", "Idempotent-Replayed", "Location", "Retry-After", "X-Request-ID", "X-Total-Count"}
+	// CORS_MAX_AGE is how long browsers cache the result of a preflight request
+	corsMaxAge, err := lookupEnvDuration("CORS_MAX_AGE", defaultCORSMaxAge)
+	if err != nil {
+		slog.Error("failed to read CORS settings: ", "error", err)
+		return 1
+	}
+
+	srv := &http.Server{
+		Addr:         ":" + s.Port,
+		Handler:      simpleCORSMiddleware(requestIDMiddleware(simpleLoggerMiddleware(maxInFlightMiddleware(rateLimitMiddleware(maxBodySizeMiddleware(apiKeyMiddleware(jwtMiddleware(gzipMiddleware(methodNotAllowedMiddleware(metricsMiddleware(mux, m))), jwtSecret), apiKey), int64(maxBodySize)), limiter), maxInFlight))), allowedOrigins, []string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS"}, exposedHeaders, corsMaxAge),
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		IdleTimeout:  idleTimeout,
+	}
+
+	// stop the server on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// start the server
+	errCh := make(chan error, 1)
+	go func() {
+		slog.Info("http server started on", "port", s.Port)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		slog.Error("failed to start server: ", "error", err)
+		return 1
+	case <-ctx.Done():
+	}
+
+	// wait for in-flight requests (e.g. item inserts) to complete before exiting
+	slog.Info("shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("failed to shut down server: ", "error", err)
+		return 1
+	}
+
+	return 0
+}
+
+// parseLogLevel parses a log level name (debug, info, warn or error) as given in LOG_LEVEL.
+// An empty name means info.
+func parseLogLevel(name string) (slog.Level, error) {
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("invalid LOG_LEVEL %q: %w", name, err)
+	}
+
+	return level, nil
+}
+
+// parseOrigins splits a comma-separated list of origins, dropping empty entries and trailing slashes.
+func parseOrigins(list string) []string {
+	var origins []string
+	for _, origin := range strings.Split(list, ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// lookupEnvInt returns the value of the environment variable key as a positive integer.
+// If the variable is not set, it returns def.
+func lookupEnvInt(key string, def int) (int, error) {
+	v, found := os.LookupEnv(key)
+	if !found {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer: %q", key, v)
+	}
+
+	return n, nil
+}
+
+// lookupEnvNonNegativeInt is like lookupEnvInt, but also accepts 0, which usually disables a feature.
+func lookupEnvNonNegativeInt(key string, def int) (int, error) {
+	v, found := os.LookupEnv(key)
+	if !found {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer: %q", key, v)
+	}
+
+	return n, nil
+}
+
+// lookupEnvDuration returns the value of the environment variable key parsed by time.ParseDuration (e.g. "10s").
+// If the variable is not set, it returns def.
+func lookupEnvDuration(key string, def time.Duration) (time.Duration, error) {
+	v, found := os.LookupEnv(key)
+	if !found {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration: %q", key, v)
+	}
+
+	return d, nil
+}
+
+type Handlers struct {
+	// imgDirPath is the path to the directory storing images.
+	imgDirPath string
+	// defaultImage is the file name of the image in imgDirPath returned for missing images.
+	// If it is empty, defaultImageName is used.
+	defaultImage string
+	// maxImageSize is the maximum width and height of stored images in pixels.
+	// If it is 0, defaultMaxImageSize is used.
+	maxImageSize int
+	// maxUploadSize is the maximum size of uploaded images in bytes.
+	// If it is 0, defaultMaxUploadSize is used.
+	maxUploadSize int64
+	// maxMultipartMemory is the part of a multipart/form-data request to add an item kept in memory in bytes.
+	// If it is 0, defaultMultipartMemory is used.
+	maxMultipartMemory int64
+	// minFreeDiskSpace is the free space in bytes below which the image directory is reported as degraded.
+	// If it is 0, defaultMinFreeDiskSpace is used.
+	minFreeDiskSpace uint64
+	// idempotencyKeys stores the responses of POST /items by Idempotency-Key.
+	// If it is nil, the header is ignored.
+	idempotencyKeys *idempotencyStore
+	// imageGroup makes concurrent uploads of the same image store it only once.
+	imageGroup singleflight.Group
+	itemRepo   ItemRepository
+}
+
+// writeJSON writes v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// writePrettyJSON writes v like writeJSON, but indented with two spaces if pretty is true.
+func writePrettyJSON(w http.ResponseWriter, status int, v any, pretty bool) {
+	if !pretty {
+		writeJSON(w, status, v)
+		return
+	}
+
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// parsePretty parses the pretty query parameter, which indents JSON responses to read them with curl.
+func parsePretty(q url.Values) (bool, error) {
+	v := q.Get("pretty")
+	if v == "" {
+		return false, nil
+	}
+	pretty, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, &requestError{Code: "invalid_pretty", Message: "pretty must be true or false"}
+	}
+	return pretty, nil
+}
+
+// encodeXML returns v encoded as an XML document.
+func encodeXML(v any) ([]byte, error) {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(xml.Header), body...), '\n'), nil
+}
+
+// writeXML writes v as an XML response with the given status code.
+func writeXML(w http.ResponseWriter, status int, v any) {
+	body, err := encodeXML(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// prefersXML reports whether the Accept header asks for XML rather than JSON.
+// JSON is the default, so it is used for ties and for Accept headers naming neither.
+func prefersXML(accept string) bool {
+	//より具体的なメディアタイプの指定を優先する(例: "*/*, application/json;q=0")
+	jsonQ, jsonSpecificity := 0.0, -1
+	xmlQ := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		specificity := -1
+		switch mediaType {
+		case "application/xml", "text/xml":
+			xmlQ = max(xmlQ, q)
+		case "application/json":
+			specificity = 2
+		case "application/*":
+			specificity = 1
+		case "*/*":
+			specificity = 0
+		}
+		if specificity > jsonSpecificity {
+			jsonQ, jsonSpecificity = q, specificity
+		}
+	}
+	return xmlQ > 0 && xmlQ > jsonQ
+}
+
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// DuplicateItemResponse is returned with 409 Conflict when the item already exists.
+type DuplicateItemResponse struct {
+	ErrorResponse
+	ID int `json:"id"`
+}
+
+// UpdateDuplicateItemResponse is returned with 409 Conflict when an update would duplicate another item.
+type UpdateDuplicateItemResponse struct {
+	ErrorResponse
+	ExistingID int `json:"existing_id"`
+}
+
+// requestError is an error caused by an invalid request.
+// Code is returned to clients as ErrorResponse.Code.
+type requestError struct {
+	Code    string
+	Message string
+}
+
+func (e *requestError) Error() string {
+	return e.Message
+}
+
+// FieldError describes a field of a request which failed validation.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationErrorResponse is returned with 400 Bad Request when fields of a request are invalid.
+// Code and Message are those of the first error, for clients which only look at one.
+type ValidationErrorResponse struct {
+	ErrorResponse
+	Errors []FieldError `json:"errors"`
+}
+
+// validationError collects all the invalid fields of a request,
+// so that clients can fix them at once instead of one per request.
+type validationError struct {
+	Errors []FieldError
+}
+
+func (e *validationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for idx, fe := range e.Errors {
+		msgs[idx] = fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// add records that field failed validation with err.
+func (e *validationError) add(field string, err *requestError) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Code: err.Code, Message: err.Message})
+}
+
+// has reports whether field has failed validation.
+func (e *validationError) has(field string) bool {
+	return slices.ContainsFunc(e.Errors, func(fe FieldError) bool { return fe.Field == field })
+}
+
+// writeError writes an ErrorResponse with the given status code.
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	writeJSON(w, status, ErrorResponse{Code: code, Message: msg})
+}
+
+// writeBadRequest writes err as a 400 response, using its code if it is a requestError.
+// A validationError is written as a ValidationErrorResponse listing all the invalid fields.
+func writeBadRequest(w http.ResponseWriter, err error) {
+	var valErr *validationError
+	if errors.As(err, &valErr) && len(valErr.Errors) > 0 {
+		first := valErr.Errors[0]
+		writeJSON(w, http.StatusBadRequest, ValidationErrorResponse{
+			ErrorResponse: ErrorResponse{Code: first.Code, Message: first.Message},
+			Errors:        valErr.Errors,
+		})
+		return
+	}
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		writeError(w, http.StatusBadRequest, reqErr.Code, reqErr.Message)
+		return
+	}
+	writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+}
+
+type HelloResponse struct {
+	Message string `json:"message"`
+}
+
+// Hello is a handler to return a Hello, world! message for GET / .
+func (s *Handlers) Hello(w http.ResponseWriter, r *http.Request) {
+	resp := HelloResponse{Message: "Hello, world!"}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Build information set at build time, e.g.
+//
+//	go build -ldflags "-X mercari-build-training/app.Commit=$(git rev-parse HEAD) -X mercari-build-training/app.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/api
+//
+// If they are not set, the VCS revision and commit time embedded by the go command are used.
+var (
+	Commit    string
+	BuildTime string
+)
+
+type VersionResponse struct {
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// buildVersion returns the build information of the running binary.
+func buildVersion() VersionResponse {
+	resp := VersionResponse{Commit: Commit, BuildTime: BuildTime, GoVersion: runtime.Version()}
+
+	//ldflagsで設定されていないとき(go runなど)は、埋め込まれたビルド情報を使う
+	if info, ok := debug.ReadBuildInfo(); ok {
+		resp.GoVersion = info.GoVersion
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && resp.Commit == "":
+				resp.Commit = setting.Value
+			case setting.Key == "vcs.time" && resp.BuildTime == "":
+				resp.BuildTime = setting.Value
+			}
+		}
+	}
+
+	return resp
+}
+
+// Version is a handler to return the build information for GET /version .
+func (s *Handlers) Version(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, buildVersion())
+}
+
+// defaultMinFreeDiskSpace is the default free space in bytes below which GET /healthz reports degraded.
+const defaultMinFreeDiskSpace = 100 << 20 // 100MB
+
+type HealthResponse struct {
+	// Status is "ok", "degraded" if the image directory is nearly full, or "unavailable".
+	Status string `json:"status"`
+	// FreeBytes is the free space for the image directory, or nil if it is unknown.
+	FreeBytes *uint64 `json:"free_bytes,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Healthz is a handler to check the server can serve and store items for GET /healthz .
+// It responds with 503 if the item repository or the image directory cannot be used,
+// and reports degraded with 200 if the image directory is nearly full, since uploads will fail soon.
+func (s *Handlers) Healthz(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.itemRepo.Count(r.Context()); err != nil {
+		slog.ErrorContext(r.Context(), "health check failed: ", "error", err)
+		writeJSON(w, http.StatusServiceUnavailable, HealthResponse{Status: "unavailable", Error: "item repository is not available"})
+		return
+	}
+
+	res := HealthResponse{Status: "ok"}
+	free, err := freeDiskSpace(s.imgDirPath)
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
+		//ディスク容量を確認できない環境ではチェックを省略する
+	case err != nil:
+		slog.ErrorContext(r.Context(), "health check failed: ", "error", err)
+		writeJSON(w, http.StatusServiceUnavailable, HealthResponse{Status: "unavailable", Error: "image directory is not available"})
+		return
+	default:
+		res.FreeBytes = &free
+		if minFree := cmp.Or(s.minFreeDiskSpace, defaultMinFreeDiskSpace); free < minFree {
+			slog.WarnContext(r.Context(), "image directory is nearly full", "path", s.imgDirPath, "free_bytes", free)
+			res.Status = "degraded"
+		}
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+type GetItemsResponse struct {
+	XMLName xml.Name `json:"-" xml:"items"`
+	Items   []*Item  `json:"items" xml:"item"`
+}
+
+// PartialItemsResponse is returned instead of GetItemsResponse when only some fields are requested.
+type PartialItemsResponse struct {
+	Items []map[string]json.RawMessage `json:"items"`
+}
+
+// itemFields are the JSON fields of an item which can be requested with the fields query parameter.
+var itemFields = []string{"id", "name", "category", "image", "image_url", "image_names", "images", "price", "stock", "description", "tags", "seller_id", "version", "created_at", "updated_at"}
+
+// parseFields parses the fields query parameter, a comma-separated list of itemFields.
+// It returns nil if no fields are requested, which means all of them.
+func parseFields(q url.Values) ([]string, error) {
+	var fields []string
+	for _, field := range strings.Split(q.Get("fields"), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" || slices.Contains(fields, field) {
+			continue
+		}
+		if !slices.Contains(itemFields, field) {
+			return nil, &requestError{Code: "invalid_field", Message: fmt.Sprintf("unknown field: %s", field)}
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// selectFields returns the JSON object of item with only the given fields.
+func selectFields(item *Item, fields []string) (map[string]json.RawMessage, error) {
+	//一度JSONにしてから、必要なキーだけを残す
+	body, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(body, &all); err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		selected[field] = all[field]
+	}
+	return selected, nil
+}
+
+// itemJSON returns the value to encode as the response for item: item itself, or only the given fields of it.
+func itemJSON(item *Item, fields []string) (any, error) {
+	if fields == nil {
+		return item, nil
+	}
+	return selectFields(item, fields)
+}
+
+// 4-3
+type GetItemsRequest struct {
+	Category string     // query parameter, optional
+	Tag      string     // query parameter, optional
+	Sort     string     // query parameter, one of itemSortKeys
+	Desc     bool       // query parameter "order"
+	Fields   []string   // query parameter, optional; nil means all fields
+	MinPrice *int       // query parameter "min_price", optional
+	MaxPrice *int       // query parameter "max_price", optional
+	Since    *time.Time // query parameter in RFC 3339, optional
+	Pretty   bool       // query parameter, optional
+}
+
+// parsePriceBound parses the price in the query parameter key. It returns nil if the parameter is not given.
+func parsePriceBound(q url.Values, key string) (*int, error) {
+	v := q.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+	price, err := strconv.Atoi(v)
+	if err != nil || price < 0 {
+		return nil, &requestError{Code: "invalid_" + key, Message: key + " must be an int of 0 or greater"}
+	}
+	return &price, nil
+}
+
+// itemSortKeys are the values accepted by the sort query parameter.
+var itemSortKeys = map[string]bool{
+	"name":       true,
+	"price":      true,
+	"created_at": true,
+}
+
+// parseGetItemsRequest parses and validates the request to list items.
+// Items are sorted newest first unless sort and order are given.
+func parseGetItemsRequest(r *http.Request) (*GetItemsRequest, error) {
+	q := r.URL.Query()
+	req := &GetItemsRequest{
+		Category: q.Get("category"),
+		Tag:      q.Get("tag"),
+		Sort:     q.Get("sort"),
+		Desc:     true,
+	}
+
+	if req.Sort == "" {
+		req.Sort = "created_at"
+	} else if !itemSortKeys[req.Sort] {
+		return nil, &requestError{Code: "invalid_sort", Message: "sort must be one of name, price or created_at"}
+	}
+
+	switch q.Get("order") {
+	case "":
+		//名前と価格はデフォルトで昇順、作成日時は新しい順
+		req.Desc = req.Sort == "created_at"
+	case "asc":
+		req.Desc = false
+	case "desc":
+		req.Desc = true
+	default:
+		return nil, &requestError{Code: "invalid_order", Message: "order must be asc or desc"}
+	}
+
+	fields, err := parseFields(q)
+	if err != nil {
+		return nil, err
+	}
+	req.Fields = fields
+
+	//価格の範囲はどちらか片方だけでも指定できる
+	if req.MinPrice, err = parsePriceBound(q, "min_price"); err != nil {
+		return nil, err
+	}
+	if req.MaxPrice, err = parsePriceBound(q, "max_price"); err != nil {
+		return nil, err
+	}
+	if req.MinPrice != nil && req.MaxPrice != nil && *req.MinPrice > *req.MaxPrice {
+		return nil, &requestError{Code: "invalid_price_range", Message: "min_price must not be greater than max_price"}
+	}
+
+	if since := q.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return nil, &requestError{Code: "invalid_since", Message: "since must be a time in RFC 3339, e.g. 2025-04-01T00:00:00Z"}
+		}
+		req.Since = &t
+	}
+
+	if req.Pretty, err = parsePretty(q); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// sortItems sorts items by the given key, breaking ties by id.
+func sortItems(items []*Item, key string, desc bool) {
+	slices.SortStableFunc(items, func(a, b *Item) int {
+		var c int
+		switch key {
+		case "name":
+			c = strings.Compare(a.Name, b.Name)
+		case "price":
+			c = cmp.Compare(a.Price, b.Price)
+		case "created_at":
+			c = a.CreatedAt.Compare(b.CreatedAt)
+		}
+		if c == 0 {
+			c = cmp.Compare(a.ID, b.ID)
+		}
+		if desc {
+			return -c
+		}
+		return c
+	})
+}
+
+// GetItem is a handler to return a itemdata for GET /items
+// The items are returned as XML if the Accept header prefers application/xml, and as JSON otherwise.
+// It responds with 304 Not Modified if If-None-Match has the ETag of the items, see itemsETag.
+// If category is given, it returns only the items in that category.
+// Items can be sorted with sort=name|price|created_at and order=asc|desc.
+// fields=name,image returns only the listed fields of each item, always as JSON.
+// min_price and max_price return only the items in that price range, both inclusive.
+// since returns only the items created at or after the given time, for clients syncing new items.
+// pretty=true indents the JSON.
+func (s *Handlers) GetItem(w http.ResponseWriter, r *http.Request) {
+	//http.Request に関連付けられたコンテキストオブジェクト(処理落ち、タイムアウトなど)を取得
+	ctx := r.Context()
+
+	req, err := parseGetItemsRequest(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	//itemsはリポジトリに保存されているので、それをリスト化して取得する
+	var items []*Item
+	if req.Category != "" {
+		items, err = s.itemRepo.ListByCategory(ctx, req.Category)
+	} else {
+		items, err = s.itemRepo.List(ctx)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get items: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	if req.Tag != "" {
+		items = slices.DeleteFunc(items, func(item *Item) bool { return !slices.Contains(item.Tags, req.Tag) })
+	}
+	if req.MinPrice != nil {
+		items = slices.DeleteFunc(items, func(item *Item) bool { return item.Price < *req.MinPrice })
+	}
+	if req.MaxPrice != nil {
+		items = slices.DeleteFunc(items, func(item *Item) bool { return item.Price > *req.MaxPrice })
+	}
+	if req.Since != nil {
+		items = slices.DeleteFunc(items, func(item *Item) bool { return item.CreatedAt.Before(*req.Since) })
+	}
+
+	//一覧が変わっていなければ本文を送らない
+	w.Header().Add("Vary", "Accept")
+	etag := itemsETag(items)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	sortItems(items, req.Sort, req.Desc)
+
+	if req.Fields != nil {
+		resp := PartialItemsResponse{Items: make([]map[string]json.RawMessage, len(items))}
+		for idx, item := range items {
+			resp.Items[idx], err = selectFields(item, req.Fields)
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to select item fields: ", "error", err)
+				writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+				return
+			}
+		}
+		writePrettyJSON(w, http.StatusOK, resp, req.Pretty)
+		return
+	}
+
+	resp := GetItemsResponse{Items: items}
+	if prefersXML(r.Header.Get("Accept")) {
+		writeXML(w, http.StatusOK, resp)
+		return
+	}
+	writePrettyJSON(w, http.StatusOK, resp, req.Pretty)
+}
+
+// ExportItemsNDJSON is a handler to download all items as newline-delimited JSON for GET /items.ndjson .
+// Each item is written on its own line and flushed right away, so that clients can process items as they arrive.
+func (s *Handlers) ExportItemsNDJSON(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	items, err := s.itemRepo.List(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get items: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w) // Encode adds a newline after each item
+	for _, item := range items {
+		if err := enc.Encode(item); err != nil {
+			//ヘッダーは送信済みなので、ログに残すだけにする
+			slog.ErrorContext(ctx, "failed to write ndjson: ", "error", err)
+			return
+		}
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			slog.ErrorContext(ctx, "failed to flush ndjson: ", "error", err)
+			return
+		}
+	}
+}
+
+// ExportItemsCSV is a handler to download all items as CSV for GET /items.csv .
+// Rows are written one by one as id,name,category,price,image.
+func (s *Handlers) ExportItemsCSV(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	items, err := s.itemRepo.List(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get items: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="items.csv"`)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"id", "name", "category", "price", "image"}); err != nil {
+		slog.ErrorContext(ctx, "failed to write csv: ", "error", err)
+		return
+	}
+	for _, item := range items {
+		row := []string{strconv.Itoa(item.ID), item.Name, item.Category, strconv.Itoa(item.Price), item.ImageName}
+		if err := cw.Write(row); err != nil {
+			//ヘッダーは送信済みなので、ログに残すだけにする
+			slog.ErrorContext(ctx, "failed to write csv: ", "error", err)
+			return
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		slog.ErrorContext(ctx, "failed to write csv: ", "error", err)
+	}
+}
+
+type CountItemsResponse struct {
+	Count int `json:"count"`
+}
+
+// RandomItem is a handler to return an item chosen at random for GET /items/random .
+// It responds with 404 if there are no items.
+func (s *Handlers) RandomItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	item, err := s.itemRepo.SelectRandom(ctx)
+	if err != nil {
+		if errors.Is(err, errItemNotFound) {
+			writeError(w, http.StatusNotFound, "item_not_found", "there are no items")
+			return
+		}
+		slog.ErrorContext(ctx, "failed to get random item: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, item)
+}
+
+// CountItems is a handler to return the number of items for GET /items/count .
+// If keyword is given, it counts the items matching it like GET /search , including the mode parameter.
+func (s *Handlers) CountItems(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var count int
+	if keyword := r.URL.Query().Get("keyword"); keyword != "" {
+		mode, err := parseSearchMode(r.URL.Query())
+		if err != nil {
+			writeBadRequest(w, err)
+			return
+		}
+		items, err := s.itemRepo.Search(ctx, keyword, mode)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to search items: ", "error", err)
+			writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		count = len(items)
+	} else {
+		n, err := s.itemRepo.Count(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to count items: ", "error", err)
+			writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		count = n
+	}
+
+	resp := CountItemsResponse{Count: count}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// parseItemID parses and validates the item id in the request path.
+func parseItemID(r *http.Request) (int, error) {
+	pid := r.PathValue("id") //リクエストのURL内に含まれているデータを見るときはPathValueを使う
+	if pid == "" {
+		return 0, &requestError{Code: "id_required", Message: "id is required"}
+	}
+
+	//取得したidをintに変換
+	id, err := strconv.Atoi(pid) //文字列を整数に変換する関数（strconv）
+	if err != nil {
+		return 0, &requestError{Code: "invalid_id", Message: "id must be an int"}
+	}
+
+	return id, nil
+}
+
+// GetAnItem is a handler to return an "one" itemdata that have requested item_id for GET /items/{id}
+// The GET /items/{id} route also matches HEAD, for which net/http sends the same headers without the body.
+// Like GetItem, the item is returned as XML if the Accept header prefers application/xml.
+// fields=name,image returns only the listed fields of the item, always as JSON.
+// pretty=true indents the JSON.
+func (s *Handlers) GetAnItem(w http.ResponseWriter, r *http.Request) {
+	fields, err := parseFields(r.URL.Query())
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	pretty, err := parsePretty(r.URL.Query())
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	item, ok := s.selectItem(w, r)
+	if !ok {
+		return
+	}
+
+	resp, err := itemJSON(item, fields)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to select item fields: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	w.Header().Set("ETag", itemETag(item))
+	w.Header().Add("Vary", "Accept")
+	if fields == nil && prefersXML(r.Header.Get("Accept")) {
+		writeXML(w, http.StatusOK, item)
+		return
+	}
+	writePrettyJSON(w, http.StatusOK, resp, pretty)
+}
+
+// selectItem looks up the item requested by GetAnItem.
+// If it fails, it writes the error response and returns false.
+func (s *Handlers) selectItem(w http.ResponseWriter, r *http.Request) (*Item, bool) {
+	//http.Request に関連付けられたコンテキストオブジェクト(処理落ち、タイムアウトなど)を取得
+	ctx := r.Context()
+
+	//URL内に含まれるidを取得
+	id, err := parseItemID(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return nil, false
+	}
+
+	//idからデータを取得する
+	item, err := s.itemRepo.Select(ctx, id)
+	if err != nil {
+		if errors.Is(err, errItemNotFound) {
+			writeError(w, http.StatusNotFound, "item_not_found", "item not found")
+			return nil, false
+		}
+		slog.ErrorContext(ctx, "failed to get item: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return nil, false
+	}
+
+	return item, true
+}
+
+// maxSearchLimit is the maximum number of items in a page of GET /search .
+const maxSearchLimit = 100
+
+type SearchRequest struct {
+	Keyword string
+	Mode    searchMode // query parameter, contains (default) or prefix
+	Limit   int        // 0 means all the items
+	Offset  int
+}
+
+type SearchResponse struct {
+	Items []*Item `json:"items"`
+	Total int     `json:"total"` // the number of all the matching items
+}
+
+// parseSearchMode parses the mode query parameter. It defaults to searchContains.
+func parseSearchMode(q url.Values) (searchMode, error) {
+	switch mode := searchMode(q.Get("mode")); mode {
+	case "":
+		return searchContains, nil
+	case searchContains, searchPrefix:
+		return mode, nil
+	default:
+		return "", &requestError{Code: "invalid_mode", Message: "mode must be contains or prefix"}
+	}
+}
+
+// parseSearchRequest parses and validates the request to search items.
+func parseSearchRequest(r *http.Request) (*SearchRequest, error) {
+	q := r.URL.Query()
+	req := &SearchRequest{Keyword: q.Get("keyword")}
+
+	//クエリパラメータからkeywordを取得
+	if strings.TrimSpace(req.Keyword) == "" {
+		return nil, &requestError{Code: "keyword_required", Message: "keyword is required"}
+	}
+
+	var err error
+	if req.Mode, err = parseSearchMode(q); err != nil {
+		return nil, err
+	}
+
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			return nil, &requestError{Code: "invalid_limit", Message: fmt.Sprintf("limit must be an int between 1 and %d", maxSearchLimit)}
+		}
+		req.Limit = n
+	}
+
+	if offset := q.Get("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			return nil, &requestError{Code: "invalid_offset", Message: "offset must be an int of 0 or greater"}
+		}
+		req.Offset = n
+	}
+
+	return req, nil
+}
+
+// Search is a handler to return items matching the keyword for GET /search .
+// Whitespace-separated terms in the keyword must all be found in the item's name or category.
+// With mode=prefix, the item's name must start with the keyword instead.
+// limit and offset select a page of the results, and total is the number of all the matching items,
+// which is also set in the X-Total-Count header for clients rendering page controls.
+func (s *Handlers) Search(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req, err := parseSearchRequest(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	items, total, err := s.itemRepo.SearchPage(ctx, req.Keyword, req.Mode, req.Limit, req.Offset)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to search items: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	resp := SearchResponse{Items: items, Total: total}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// maxSuggestions is the maximum number of suggestions returned by GET /suggest .
+const maxSuggestions = 10
+
+type SuggestResponse struct {
+	Suggestions []string `json:"suggestions"`
+}
+
+// Suggest is a handler to return item and category names starting with a prefix for GET /suggest .
+// An empty prefix returns no suggestions.
+func (s *Handlers) Suggest(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeJSON(w, http.StatusOK, SuggestResponse{Suggestions: []string{}})
+		return
+	}
+
+	suggestions, err := s.itemRepo.Suggest(ctx, q, maxSuggestions)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get suggestions: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	resp := SuggestResponse{Suggestions: suggestions}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// GetItemImage is a handler to redirect to the image of an item for GET /items/{id}/image .
+// If the item doesn't exist or has no image, it redirects to the default image.
+func (s *Handlers) GetItemImage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := parseItemID(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	imageName := s.defaultImage
+	if imageName == "" {
+		imageName = defaultImageName
+	}
+	item, err := s.itemRepo.Select(ctx, id)
+	if err != nil && !errors.Is(err, errItemNotFound) {
+		slog.ErrorContext(ctx, "failed to get item: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if item != nil && item.ImageName != "" {
+		imageName = item.ImageName
+	}
+
+	//itemの画像は変更されうるので、リダイレクト自体はキャッシュさせない
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, "/images/"+url.PathEscape(imageName), http.StatusFound)
+}
+
+// defaultSimilarLimit is the number of similar items returned unless limit is given.
+const defaultSimilarLimit = 10
+
+// GetSimilarItems is a handler to return other items in the category of an item for GET /items/{id}/similar .
+// The number of items is limited by the limit query parameter (default: 10).
+func (s *Handlers) GetSimilarItems(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := parseItemID(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	limit := defaultSimilarLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			writeError(w, http.StatusBadRequest, "invalid_limit", fmt.Sprintf("limit must be an int between 1 and %d", maxSearchLimit))
+			return
+		}
+	}
+
+	items, err := s.itemRepo.ListSimilar(ctx, id, limit)
+	if err != nil {
+		if errors.Is(err, errItemNotFound) {
+			writeError(w, http.StatusNotFound, "item_not_found", "item not found")
+			return
+		}
+		slog.ErrorContext(ctx, "failed to get similar items: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	resp := GetItemsResponse{Items: items}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// PurchaseItem is a handler to buy one of an item for POST /items/{id}/purchase .
+// It responds with the updated item, or 409 Conflict if the item is out of stock.
+func (s *Handlers) PurchaseItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := parseItemID(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	item, err := s.itemRepo.Purchase(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, errItemNotFound):
+			writeError(w, http.StatusNotFound, "item_not_found", "item not found")
+		case errors.Is(err, errOutOfStock):
+			writeError(w, http.StatusConflict, "out_of_stock", "item is out of stock")
+		default:
+			slog.ErrorContext(ctx, "failed to purchase item: ", "error", err)
+			writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		}
+		return
+	}
+
+	slog.InfoContext(ctx, "item purchased", "id", item.ID, "stock", item.Stock)
+	writeJSON(w, http.StatusOK, item)
+}
+
+// DeleteItem is a handler to delete an item for DELETE /items/{id} .
+// The item is only marked as deleted and can be restored with POST /items/{id}/restore .
+// Its image is kept, since other items may share the same hashed file; gc-images removes unreferenced ones.
+func (s *Handlers) DeleteItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := parseItemID(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	err = s.itemRepo.Delete(ctx, id)
+	if err != nil {
+		if errors.Is(err, errItemNotFound) {
+			writeError(w, http.StatusNotFound, "item_not_found", "item not found")
+			return
+		}
+		slog.ErrorContext(ctx, "failed to delete item: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminGetItems is a handler to return all items including deleted ones for GET /admin/items .
+// Deleted items have deleted_at set. GET /items never returns them.
+func (s *Handlers) AdminGetItems(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	items, err := s.itemRepo.ListAll(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get items: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	resp := GetItemsResponse{Items: items}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// MyItems is a handler to return the items of the authenticated seller for GET /my/items .
+// jwtMiddleware rejects requests without a token, so the seller is unknown only if JWT_SECRET is not set.
+func (s *Handlers) MyItems(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	sellerID := sellerIDFromContext(ctx)
+	if sellerID == "" {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "a bearer token is required")
+		return
+	}
+
+	items, err := s.itemRepo.ListBySeller(ctx, sellerID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get items: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	resp := GetItemsResponse{Items: items}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// RestoreItem is a handler to restore a deleted item for POST /items/{id}/restore .
+// It responds with the restored item, or 409 Conflict if an item with the same name and category has been added since.
+func (s *Handlers) RestoreItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := parseItemID(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	item, err := s.itemRepo.Restore(ctx, id)
+	if err != nil {
+		if errors.Is(err, errItemNotFound) {
+			writeError(w, http.StatusNotFound, "item_not_found", "item not found")
+			return
+		}
+		if errors.Is(err, errDuplicateItem) {
+			writeError(w, http.StatusConflict, "duplicate_item", "an item with the same name and category exists")
+			return
+		}
+		slog.ErrorContext(ctx, "failed to restore item: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, item)
+}
+
+type GetCategoriesResponse struct {
+	Categories []*Category `json:"categories"`
+}
+
+// GetCategories is a handler to return all categories for GET /categories .
+func (s *Handlers) GetCategories(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	categories, err := s.itemRepo.ListCategories(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get categories: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	resp := GetCategoriesResponse{Categories: categories}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type GetCategoryNamesResponse struct {
+	Categories []string `json:"categories"`
+}
+
+// GetCategoryNames is a handler to return the category names sorted by name for GET /items/categories .
+// It is a lighter alternative to GET /categories for clients which only need the names, such as a dropdown.
+func (s *Handlers) GetCategoryNames(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	categories, err := s.itemRepo.ListCategories(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get categories: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	names := make([]string, len(categories))
+	for idx, c := range categories {
+		names[idx] = c.Name
+	}
+	slices.Sort(names)
+
+	resp := GetCategoryNamesResponse{Categories: names}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// DeleteCategory is a handler to delete a category for DELETE /categories/{name}?reassign_to={other} .
+// The items in the category are moved to reassign_to, an existing category, so that no item is left without one.
+// Categories only exist while they have items, so reassign_to is always required.
+// It responds with 409 if reassign_to already has an item with the same name as a moved one, and nothing is moved.
+func (s *Handlers) DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	name := r.PathValue("name")
+	reassignTo := strings.TrimSpace(r.URL.Query().Get("reassign_to"))
+
+	categories, err := s.itemRepo.ListCategories(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get categories: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	exists := func(name string) bool {
+		return slices.ContainsFunc(categories, func(c *Category) bool { return c.Name == name })
+	}
+	if !exists(name) {
+		writeError(w, http.StatusNotFound, "category_not_found", fmt.Sprintf("category not found: %s", name))
+		return
+	}
+
+	//itemが行き場を失わないように、移動先がなければ削除しない
+	switch {
+	case reassignTo == "":
+		writeError(w, http.StatusBadRequest, "reassign_to_required", "the category has items, so reassign_to is required to move them")
+		return
+	case reassignTo == name:
+		writeError(w, http.StatusBadRequest, "invalid_reassign_to", "reassign_to must be another category")
+		return
+	case !exists(reassignTo):
+		writeError(w, http.StatusBadRequest, "invalid_reassign_to", fmt.Sprintf("category not found: %s", reassignTo))
+		return
+	}
+
+	moved, err := s.itemRepo.ReassignCategory(ctx, name, reassignTo)
+	if err != nil {
+		if errors.Is(err, errDuplicateItem) {
+			writeError(w, http.StatusConflict, "duplicate_item", fmt.Sprintf("an item in %s has the same name as one in %s", name, reassignTo))
+			return
+		}
+		slog.ErrorContext(ctx, "failed to reassign category: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	slog.InfoContext(ctx, "category deleted", "category", name, "reassign_to", reassignTo, "moved", moved)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type MergeCategoriesRequest struct {
+	From string `json:"from"`
+	Into string `json:"into"`
+}
+
+type MergeCategoriesResponse struct {
+	Moved int `json:"moved"`
+}
+
+// parseMergeCategoriesRequest parses the JSON body of a request to merge categories.
+func parseMergeCategoriesRequest(r *http.Request) (*MergeCategoriesRequest, error) {
+	var req MergeCategoriesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, &requestError{Code: "invalid_json", Message: fmt.Sprintf("failed to decode request body: %v", err)}
+	}
+	req.From = strings.TrimSpace(req.From)
+	req.Into = strings.TrimSpace(req.Into)
+
+	switch {
+	case req.From == "":
+		return nil, &requestError{Code: "from_required", Message: "from is required"}
+	case req.Into == "":
+		return nil, &requestError{Code: "into_required", Message: "into is required"}
+	case req.From == req.Into:
+		return nil, &requestError{Code: "invalid_into", Message: "into must be another category"}
+	}
+
+	return &req, nil
+}
+
+// MergeCategories is a handler to move all items of one category to another for POST /categories/merge ,
+// e.g. {"from":"shoe","into":"Shoes"}. The category from disappears as it has no items left.
+// It responds with the number of items moved, or 409 if an item in from has the same name as one in into.
+func (s *Handlers) MergeCategories(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req, err := parseMergeCategoriesRequest(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	categories, err := s.itemRepo.ListCategories(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get categories: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	for _, name := range []string{req.From, req.Into} {
+		if !slices.ContainsFunc(categories, func(c *Category) bool { return c.Name == name }) {
+			writeError(w, http.StatusNotFound, "category_not_found", fmt.Sprintf("category not found: %s", name))
+			return
+		}
+	}
+
+	moved, err := s.itemRepo.ReassignCategory(ctx, req.From, req.Into)
+	if err != nil {
+		if errors.Is(err, errDuplicateItem) {
+			writeError(w, http.StatusConflict, "duplicate_item", fmt.Sprintf("an item in %s has the same name as one in %s", req.From, req.Into))
+			return
+		}
+		slog.ErrorContext(ctx, "failed to merge categories: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	slog.InfoContext(ctx, "categories merged", "from", req.From, "into", req.Into, "moved", moved)
+
+	writeJSON(w, http.StatusOK, MergeCategoriesResponse{Moved: moved})
+}
+
+type StatsResponse struct {
+	Items      int `json:"items"`
+	Categories int `json:"categories"`
+}
+
+// Stats is a handler to return the number of items and categories for GET /stats .
+func (s *Handlers) Stats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	count, err := s.itemRepo.Count(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to count items: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	categories, err := s.itemRepo.ListCategories(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get categories: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	resp := StatsResponse{Items: count, Categories: len(categories)}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// GetCategoryItems is a handler to return the items in a category for GET /categories/{name}/items .
+// It responds with 404 if there is no such category.
+func (s *Handlers) GetCategoryItems(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	name := r.PathValue("name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "category_required", "category is required")
+		return
+	}
+
+	categories, err := s.itemRepo.ListCategories(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get categories: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if !slices.ContainsFunc(categories, func(c *Category) bool { return c.Name == name }) {
+		writeError(w, http.StatusNotFound, "category_not_found", fmt.Sprintf("category not found: %s", name))
+		return
+	}
+
+	items, err := s.itemRepo.ListByCategory(ctx, name)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get items: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	resp := GetItemsResponse{Items: items}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// maxTags is the maximum number of tags on an item, and maxTagLength the maximum number of characters in a tag.
+const (
+	maxTags      = 10
+	maxTagLength = 30
+)
+
+// normalizeTags trims the tags and drops empty and duplicate ones, keeping their order.
+func normalizeTags(tags []string) ([]string, error) {
+	var result []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || slices.Contains(result, tag) {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, &requestError{Code: "invalid_tag", Message: fmt.Sprintf("tags must be at most %d characters", maxTagLength)}
+		}
+		result = append(result, tag)
+	}
+	if len(result) > maxTags {
+		return nil, &requestError{Code: "too_many_tags", Message: fmt.Sprintf("an item can have at most %d tags", maxTags)}
+	}
+	return result, nil
+}
+
+// maxDescriptionLength is the maximum number of characters in an item description.
+const maxDescriptionLength = 1000
+
+// maxNameLength is the maximum number of characters in an item name or category.
+const maxNameLength = 100
+
+// normalizeName trims the value of the name or category field and checks that it is neither empty nor too long.
+func normalizeName(field, value string) (string, *requestError) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", &requestError{Code: field + "_required", Message: field + " is required"}
+	}
+	if utf8.RuneCountInString(value) > maxNameLength {
+		return "", &requestError{Code: field + "_too_long", Message: fmt.Sprintf("%s must be at most %d characters", field, maxNameLength)}
+	}
+	return value, nil
+}
+
+type AddItemRequest struct {
+	Name        string           `form:"name"`
+	Category    string           `form:"category"` // STEP 4-2: add a category field //<-Done
+	Image       *uploadedImage   `form:"image"`    // STEP 4-4: add an image field //画像は一時ファイルに書き出してから保存する
+	ExtraImages []*uploadedImage `form:"image"`    // optional, the images after the first one in forms
+	Price       int              `form:"price"`
+	Description string           `form:"description"` // optional
+	Tags        []string         `form:"tags"`        // optional, repeated or comma-separated in forms
+	Stock       int              `form:"stock"`       // optional, defaultStock if omitted
+}
+
+// images returns all the uploaded images of the request, the main one first.
+func (req *AddItemRequest) images() []*uploadedImage {
+	if req == nil || req.Image == nil {
+		return nil
+	}
+	return append([]*uploadedImage{req.Image}, req.ExtraImages...)
+}
+
+// removeImages removes the temporary files of the uploaded images.
+func (req *AddItemRequest) removeImages() {
+	for _, img := range req.images() {
+		img.remove()
+	}
+}
+
+type AddItemResponse struct {
+	ID      int    `json:"id"`
+	Message string `json:"message"`
+}
+
+// ValidateItemResponse is returned by POST /items?validate_only=true when the item is valid.
+type ValidateItemResponse struct {
+	Valid bool `json:"valid"`
+}
+
+// parseValidateOnly parses the validate_only query parameter of POST /items .
+func parseValidateOnly(q url.Values) (bool, error) {
+	v := q.Get("validate_only")
+	if v == "" {
+		return false, nil
+	}
+	validateOnly, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, &requestError{Code: "invalid_validate_only", Message: "validate_only must be true or false"}
+	}
+	return validateOnly, nil
+}
+
+// withoutImageRequired removes the image_required error from err, so that a form can be validated
+// before its image is uploaded. It returns nil if no other field is invalid.
+func withoutImageRequired(err error) error {
+	var valErr *validationError
+	if !errors.As(err, &valErr) {
+		return err
+	}
+	valErr.Errors = slices.DeleteFunc(valErr.Errors, func(fe FieldError) bool { return fe.Code == "image_required" })
+	if len(valErr.Errors) == 0 {
+		return nil
+	}
+	return valErr
+}
+
+// defaultMaxUploadSize is the default maximum size of uploaded images in bytes.
+const defaultMaxUploadSize = 5 << 20 // 5MB
+
+// defaultMaxBodySize is the default maximum size of request bodies in bytes.
+// It is large enough for an image of defaultMaxUploadSize encoded in base64 in a JSON request.
+const defaultMaxBodySize = 10 << 20 // 10MB
+
+// parseAddItemRequest parses and validates the request to add an item.
+// The request body is either multipart/form-data or, for programmatic clients,
+// application/json with the image encoded in base64.
+// Images larger than maxUploadSize bytes are rejected with errImageTooLarge,
+// and bodies larger than the limit set by maxBodySizeMiddleware with errBodyTooLarge.
+// Invalid fields are all reported together in a validationError.
+// Up to maxMemory bytes of a multipart/form-data body are kept in memory and the rest in temporary files,
+// which are removed before it returns.
+// The images are spooled to temporary files in imgDirPath, which the caller removes with req.removeImages.
+// They are already removed if it returns an error.
+func parseAddItemRequest(r *http.Request, imgDirPath string, maxUploadSize, maxMemory int64) (*AddItemRequest, error) {
+	var req *AddItemRequest
+	var err error
+	errs := &validationError{}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		req, err = decodeAddItemJSON(r, imgDirPath, maxUploadSize, errs)
+	} else {
+		req, err = parseAddItemForm(r, imgDirPath, maxUploadSize, maxMemory, errs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// validate the request
+	//最初のエラーで返さず、すべての項目を検証してからまとめて返す
+	//前後の空白は取り除いてから検証する
+	var nameErr *requestError
+	if req.Name, nameErr = normalizeName("name", req.Name); nameErr != nil {
+		errs.add("name", nameErr)
+	}
+
+	if req.Category, nameErr = normalizeName("category", req.Category); nameErr != nil { // STEP 4-2: validate the category field //<- Done
+		errs.add("category", nameErr)
+	}
+
+	if !errs.has("image") {
+		if req.Image.size == 0 { // STEP 4-4: validate the image field //<-DOne
+			errs.add("image", &requestError{Code: "image_empty", Message: "Uploaded image is empty"})
+		} else if err := req.Image.decode(); err != nil {
+			//画像として読み込めないファイルは受け付けない
+			errs.add("image", &requestError{Code: "invalid_image", Message: "uploaded file is not a valid image"})
+		}
+	}
+	for idx, img := range req.ExtraImages {
+		if errs.has("image") {
+			break
+		}
+		if err := img.decode(); err != nil {
+			errs.add("image", &requestError{Code: "invalid_image", Message: fmt.Sprintf("uploaded file %d is not a valid image", idx+2)})
+		}
+	}
+
+	//priceは円単位の0以上の整数
+	if !errs.has("price") && req.Price < 0 {
+		errs.add("price", &requestError{Code: "invalid_price", Message: "price must be 0 or greater"})
+	}
+
+	if utf8.RuneCountInString(req.Description) > maxDescriptionLength {
+		errs.add("description", &requestError{Code: "description_too_long", Message: fmt.Sprintf("description must be at most %d characters", maxDescriptionLength)})
+	}
+
+	req.Tags, err = normalizeTags(req.Tags)
+	var tagErr *requestError
+	if errors.As(err, &tagErr) {
+		errs.add("tags", tagErr)
+	}
+
+	if !errs.has("stock") && req.Stock < 0 {
+		errs.add("stock", &requestError{Code: "invalid_stock", Message: "stock must be 0 or greater"})
+	}
+
+	if len(errs.Errors) > 0 {
+		req.removeImages()
+		//フォームの項目の順に並べる
+		slices.SortStableFunc(errs.Errors, func(a, b FieldError) int {
+			return slices.Index(addItemFields, a.Field) - slices.Index(addItemFields, b.Field)
+		})
+		return nil, errs
+	}
+
+	return req, nil
+}
+
+// addItemFields are the fields of a request to add an item, in the order validation errors are reported.
+var addItemFields = []string{"name", "category", "image", "price", "description", "tags", "stock"}
+
+// maxItemImages is the maximum number of images of an item.
+const maxItemImages = 10
+
+// defaultMultipartMemory is the default part of a multipart/form-data body kept in memory.
+// The rest is written to temporary files while the request is parsed.
+const defaultMultipartMemory = 10 << 20 // 10MB
+
+// parseAddItemForm reads the fields of a multipart/form-data request to add an item,
+// spooling the images to imgDirPath. Fields which cannot be read are added to errs.
+func parseAddItemForm(r *http.Request, imgDirPath string, maxUploadSize, maxMemory int64, errs *validationError) (*AddItemRequest, error) {
+	//FormValueは読み込みのエラーを返さないので、先にフォームを解析してボディが上限を超えていないか確認する
+	var maxBytesErr *http.MaxBytesError
+	if err := r.ParseMultipartForm(maxMemory); errors.As(err, &maxBytesErr) {
+		return nil, errBodyTooLarge
+	}
+	//メモリに収まらなかった部分の一時ファイルは、画像を読み終えたら消す
+	if r.MultipartForm != nil {
+		defer r.MultipartForm.RemoveAll()
+	}
+
+	req := &AddItemRequest{
+		Name:        r.FormValue("name"),
+		Category:    r.FormValue("category"), // STEP 4-2: add a category field // <- Done
+		Description: r.FormValue("description"),
+	}
+	//タグは複数のtagsフィールドでも、カンマ区切りでも受け付ける
+	for _, v := range r.Form["tags"] {
+		req.Tags = append(req.Tags, strings.Split(v, ",")...)
+	}
+
+	// STEP 4-4: add an image field
+	//imageを複数送ると、最初のものがメインの画像になる
+	var files []*multipart.FileHeader
+	if r.MultipartForm != nil {
+		files = r.MultipartForm.File["image"]
+	}
+	switch {
+	case len(files) == 0:
+		errs.add("image", &requestError{Code: "image_required", Message: "image is required"})
+	case len(files) > maxItemImages:
+		errs.add("image", &requestError{Code: "too_many_images", Message: fmt.Sprintf("at most %d images can be uploaded", maxItemImages)})
+	default:
+		for idx, file := range files {
+			img, err := spoolUploadedFile(file, imgDirPath, maxUploadSize)
+			if err != nil {
+				req.removeImages()
+				return nil, err
+			}
+			if idx == 0 {
+				req.Image = img
+			} else {
+				req.ExtraImages = append(req.ExtraImages, img)
+			}
+		}
+	}
+
+	var err error
+	if price := r.FormValue("price"); price == "" {
+		errs.add("price", &requestError{Code: "price_required", Message: "price is required"})
+	} else if req.Price, err = strconv.Atoi(price); err != nil {
+		errs.add("price", &requestError{Code: "invalid_price", Message: "price must be an int"})
+	}
+
+	req.Stock = defaultStock
+	if stock := r.FormValue("stock"); stock != "" {
+		if req.Stock, err = strconv.Atoi(stock); err != nil {
+			errs.add("stock", &requestError{Code: "invalid_stock", Message: "stock must be an int"})
+		}
+	}
+
+	return req, nil
+}
+
+// spoolUploadedFile spools an image of a multipart/form-data request to imgDirPath (see spoolImage).
+func spoolUploadedFile(file *multipart.FileHeader, imgDirPath string, maxUploadSize int64) (*uploadedImage, error) {
+	uploadedFile, err := file.Open()
+	if err != nil {
+		return nil, &requestError{Code: "invalid_image", Message: fmt.Sprintf("failed to read image file: %v", err)}
+	}
+	defer uploadedFile.Close()
+
+	return spoolImage(imgDirPath, uploadedFile, maxUploadSize)
+}
+
+// uploadedImage is an uploaded image spooled to a temporary file in the image directory.
+// The upload is hashed while it is written, so that it is never held in memory as a whole.
+// The file must be removed with remove once the request is done.
+type uploadedImage struct {
+	path string
+	size int64
+	sum  string // hex-encoded SHA-256 of the upload
+}
+
+// spoolImage copies an uploaded image from r to a temporary file in dir while hashing it.
+// The image must be at most maxUploadSize bytes, or it returns errImageTooLarge.
+func spoolImage(dir string, r io.Reader, maxUploadSize int64) (*uploadedImage, error) {
+	tmp, err := os.CreateTemp(dir, "upload-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+
+	//上限+1バイトまでしか読まないことで、巨大なファイルを書き込まない
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), io.LimitReader(r, maxUploadSize+1))
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to spool image: %w", err)
+	}
+	if size > maxUploadSize {
+		os.Remove(tmp.Name())
+		return nil, errImageTooLarge
+	}
+
+	return &uploadedImage{path: tmp.Name(), size: size, sum: hex.EncodeToString(hasher.Sum(nil))}, nil
+}
+
+// contentType detects the content type of the image from its first bytes.
+func (u *uploadedImage) contentType() (string, error) {
+	f, err := os.Open(u.path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	//http.DetectContentTypeは先頭512バイトしか見ない
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+// decode reports whether the image can be decoded, reading it from the file.
+func (u *uploadedImage) decode() error {
+	f, err := os.Open(u.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, _, err = image.Decode(f)
+	return err
+}
+
+// remove removes the temporary file. It does nothing once the file has been stored under its hash.
+func (u *uploadedImage) remove() {
+	os.Remove(u.path)
+}
+
+// maxJSONFieldsSize is the room left for fields other than the image in a JSON request to add an item.
+const maxJSONFieldsSize = 64 << 10 // 64KB
+
+// decodeAddItemJSON reads the body of an application/json request to add an item.
+// Fields which are missing are added to errs.
+func decodeAddItemJSON(r *http.Request, imgDirPath string, maxUploadSize int64, errs *validationError) (*AddItemRequest, error) {
+	var body struct {
+		Name        string   `json:"name"`
+		Category    string   `json:"category"`
+		Image       []byte   `json:"image"` // base64 encoded
+		Price       *int     `json:"price"`
+		Description string   `json:"description"`
+		Tags        []string `json:"tags"`
+		Stock       *int     `json:"stock"`
+	}
+
+	//base64にすると画像は4/3倍になるので、その分を見込んで読み込む量を制限する
+	limit := int64(base64.StdEncoding.EncodedLen(int(maxUploadSize))) + maxJSONFieldsSize
+	if err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, limit)).Decode(&body); err != nil {
+		//どちらの上限を超えたかで、画像が大きすぎるのかボディ全体が大きすぎるのかを区別する
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			if maxBytesErr.Limit == limit {
+				return nil, errImageTooLarge
+			}
+			return nil, errBodyTooLarge
+		}
+		return nil, &requestError{Code: "invalid_json", Message: fmt.Sprintf("failed to decode request body: %v", err)}
+	}
+
+	if int64(len(body.Image)) > maxUploadSize {
+		return nil, errImageTooLarge
+	}
+	var img *uploadedImage
+	if len(body.Image) == 0 {
+		errs.add("image", &requestError{Code: "image_required", Message: "image is required"})
+	} else {
+		//JSONではbase64のデコードでメモリに載るが、保存はフォームと同じく一時ファイルから行う
+		var err error
+		if img, err = spoolImage(imgDirPath, bytes.NewReader(body.Image), maxUploadSize); err != nil {
+			return nil, err
+		}
+	}
+	price := 0
+	if body.Price == nil {
+		errs.add("price", &requestError{Code: "price_required", Message: "price is required"})
+	} else {
+		price = *body.Price
+	}
+	stock := defaultStock
+	if body.Stock != nil {
+		stock = *body.Stock
+	}
+
+	return &AddItemRequest{
+		Name:        body.Name,
+		Category:    body.Category,
+		Image:       img,
+		Price:       price,
+		Description: body.Description,
+		Tags:        body.Tags,
+		Stock:       stock,
+	}, nil
+}
+
+// AddItem is a handler to add a new item for POST /items .
+// It responds with 201 Created and the location of the new item.
+// With validate_only=true, the request is only validated and 200 with {"valid":true} is returned
+// without storing anything. The image may then be left out, to validate a form before uploading it.
+func (s *Handlers) AddItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	validateOnly, err := parseValidateOnly(r.URL.Query())
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	//同じ Idempotency-Key のリクエストには、最初のレスポンスを返す
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if s.idempotencyKeys == nil || validateOnly {
+		idempotencyKey = ""
+	}
+	if idempotencyKey != "" {
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			writeError(w, http.StatusBadRequest, "invalid_idempotency_key", fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength))
+			return
+		}
+
+		//キーは出品者ごとに分ける(他の出品者の保存済みレスポンスを返さないように)
+		idempotencyKey = sellerIDFromContext(r.Context()) + "\x00" + idempotencyKey
+		stored, err := s.idempotencyKeys.reserve(idempotencyKey)
+		if err != nil {
+			writeError(w, http.StatusConflict, "idempotency_key_in_use", "a request with the same Idempotency-Key is in progress")
+			return
+		}
+		if stored != nil {
+			w.Header().Set("Location", fmt.Sprintf("/items/%d", stored.ID))
+			w.Header().Set("Idempotent-Replayed", "true")
+			writeJSON(w, http.StatusCreated, stored)
+			return
+		}
+	}
+
+	completed := false
+	defer func() {
+		//失敗したときは同じキーで再試行できるようにする
+		if idempotencyKey != "" && !completed {
+			s.idempotencyKeys.release(idempotencyKey)
+		}
+	}()
+
+	maxUploadSize := s.maxUploadSize
+	if maxUploadSize <= 0 {
+		maxUploadSize = defaultMaxUploadSize
+	}
+
+	req, err := parseAddItemRequest(r, s.imgDirPath, maxUploadSize, cmp.Or(s.maxMultipartMemory, defaultMultipartMemory))
+	if validateOnly {
+		err = withoutImageRequired(err)
+	}
+	if err != nil {
+		if errors.Is(err, errImageTooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "image_too_large", fmt.Sprintf("image must be at most %d bytes", maxUploadSize))
+			return
+		}
+		if errors.Is(err, errBodyTooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "request_too_large", "request body is too large")
+			return
+		}
+		//リクエストの誤りではなく、画像を一時ファイルに書き出せなかったとき
+		var reqErr *requestError
+		var valErr *validationError
+		if !errors.As(err, &reqErr) && !errors.As(err, &valErr) {
+			slog.ErrorContext(ctx, "failed to read image: ", "error", err)
+			writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		writeBadRequest(w, err)
+		return
+	}
+	//保存した画像は一時ファイルではなくなるので、残っているものだけが消える
+	defer req.removeImages()
 
-	// STEP 5-1: set up the database connection
+	//検証だけのときは、画像もitemも保存しない
+	if validateOnly {
+		for _, img := range req.images() {
+			contentType, err := img.contentType()
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to read image: ", "error", err)
+				writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+				return
+			}
+			if _, ok := imageExtensions[contentType]; img.size > 0 && !ok {
+				writeError(w, http.StatusBadRequest, "unsupported_image_type", "image must be a JPEG, PNG or WebP")
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, ValidateItemResponse{Valid: true})
+		return
+	}
 
-	// set up handlers
-	itemRepo := NewItemRepository()
-	h := &Handlers{imgDirPath: s.ImageDirPath, itemRepo: itemRepo}
+	// STEP 4-4: uncomment on adding an implementation to store an image //ファイル名をハッシュ化
+	var fileNames []string
+	for _, img := range req.images() {
+		fileName, err := s.storeImage(img)
+		if err != nil {
+			if errors.Is(err, errUnsupportedImageType) {
+				writeError(w, http.StatusBadRequest, "unsupported_image_type", "image must be a JPEG, PNG or WebP")
+				return
+			}
+			slog.ErrorContext(ctx, "failed to store image: ", "error", err)
+			writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		//同じ画像が複数回送られたときは1つにまとめる
+		if !slices.Contains(fileNames, fileName) {
+			fileNames = append(fileNames, fileName)
+		}
+	}
 
-	// set up routes
-	mux := http.NewServeMux()
-	mux.HandleFunc("GET /", h.Hello)
-	mux.HandleFunc("GET /items", h.GetItem)
-	mux.HandleFunc("GET /items/{id}", h.GetAnItem)
-	mux.HandleFunc("POST /items", h.AddItem)
-	mux.HandleFunc("GET /images/{filename}", h.GetImage)
+	item := &Item{
+		Name:     req.Name,
+		Category: req.Category, // STEP 4-2: add a category field //<-Done
+		// STEP 4-4: add an image field
+		ImageName:   fileNames[0],
+		ImageNames:  fileNames,
+		Price:       req.Price,
+		Description: req.Description,
+		Tags:        req.Tags,
+		Stock:       req.Stock,
+		SellerID:    sellerIDFromContext(ctx),
+	}
+	message := fmt.Sprintf("item received: %s", item.Name)
 
-	// start the server
-	slog.Info("http server started on", "port", s.Port)
-	err := http.ListenAndServe(":"+s.Port, simpleCORSMiddleware(simpleLoggerMiddleware(mux), frontURL, []string{"GET", "HEAD", "POST", "OPTIONS"}))
+	// STEP 4-2: add an implementation to store an item
+	id, err := s.itemRepo.Insert(ctx, item)
 	if err != nil {
-		slog.Error("failed to start server: ", "error", err)
-		return 1
+		if errors.Is(err, errDuplicateItem) {
+			writeJSON(w, http.StatusConflict, DuplicateItemResponse{
+				ErrorResponse: ErrorResponse{Code: "duplicate_item", Message: fmt.Sprintf("item already exists: id %d", id)},
+				ID:            id,
+			})
+			return
+		}
+		slog.ErrorContext(ctx, "failed to store item: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
 	}
 
-	return 0
+	//検索できるように項目ごとに記録する(画像は中身ではなくファイル名だけ)
+	slog.InfoContext(ctx, "item created", "id", id, "name", item.Name, "category", item.Category, "image", item.ImageName,
+		"price", item.Price, "stock", item.Stock, "tags", item.Tags, "seller_id", item.SellerID)
+
+	resp := AddItemResponse{ID: id, Message: message}
+	if idempotencyKey != "" {
+		s.idempotencyKeys.complete(idempotencyKey, &resp)
+		completed = true
+	}
+	w.Header().Set("Location", fmt.Sprintf("/items/%d", id))
+	writeJSON(w, http.StatusCreated, resp)
 }
 
-type Handlers struct {
-	// imgDirPath is the path to the directory storing images.
-	imgDirPath string
-	itemRepo   ItemRepository
+// maxBulkItems is the maximum number of items in a request to POST /items/bulk .
+const maxBulkItems = 1000
+
+// BulkAddItemRequest is an item in a request to POST /items/bulk . Images are not supported.
+type BulkAddItemRequest struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Price    *int   `json:"price"`
 }
 
-type HelloResponse struct {
-	Message string `json:"message"`
+type BulkAddItemsResponse struct {
+	IDs []int `json:"ids"`
 }
 
-// Hello is a handler to return a Hello, world! message for GET / .
-func (s *Handlers) Hello(w http.ResponseWriter, r *http.Request) {
-	resp := HelloResponse{Message: "Hello, world!"}
-	err := json.NewEncoder(w).Encode(resp)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+// BulkErrorResponse is returned when an item of a bulk request fails. Index is the position of the item.
+type BulkErrorResponse struct {
+	ErrorResponse
+	Index int `json:"index"`
+	ID    int `json:"id,omitempty"`
 }
 
-type GetItemsResponse struct {
-	Items []*Item `json:"items"`
+// bulkRequestError is a requestError of the item at Index in a bulk request.
+type bulkRequestError struct {
+	Index int
+	*requestError
 }
 
-// 4-3
-// GetItem is a handler to return a itemdata for GET /items
-func (s *Handlers) GetItem(w http.ResponseWriter, r *http.Request) {
-	//http.Request に関連付けられたコンテキストオブジェクト(処理落ち、タイムアウトなど)を取得
+// parseBulkAddItemsRequest parses and validates the request to add items in bulk.
+// Bodies larger than maxSize bytes are rejected with errBodyTooLarge.
+func parseBulkAddItemsRequest(r *http.Request, maxSize int64) ([]*Item, error) {
+	var body []BulkAddItemRequest
+	if err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxSize)).Decode(&body); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, errBodyTooLarge
+		}
+		return nil, &requestError{Code: "invalid_json", Message: fmt.Sprintf("failed to decode request body: %v", err)}
+	}
+	if len(body) == 0 {
+		return nil, &requestError{Code: "items_required", Message: "at least one item is required"}
+	}
+	if len(body) > maxBulkItems {
+		return nil, &requestError{Code: "too_many_items", Message: fmt.Sprintf("at most %d items can be added at once", maxBulkItems)}
+	}
+
+	items := make([]*Item, 0, len(body))
+	for idx, req := range body {
+		name, nameErr := normalizeName("name", req.Name)
+		category, categoryErr := normalizeName("category", req.Category)
+		var reqErr *requestError
+		switch {
+		case nameErr != nil:
+			reqErr = nameErr
+		case categoryErr != nil:
+			reqErr = categoryErr
+		case req.Price == nil:
+			reqErr = &requestError{Code: "price_required", Message: "price is required"}
+		case *req.Price < 0:
+			reqErr = &requestError{Code: "invalid_price", Message: "price must be 0 or greater"}
+		}
+		if reqErr != nil {
+			return nil, &bulkRequestError{Index: idx, requestError: reqErr}
+		}
+
+		items = append(items, &Item{Name: name, Category: category, Price: *req.Price, Stock: defaultStock})
+	}
+
+	return items, nil
+}
+
+// BulkAddItems is a handler to add items at once for POST /items/bulk .
+// Either all the items are added or, if any of them fails, none of them.
+func (s *Handlers) BulkAddItems(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	//itemsはリポジトリに保存されているので、それをリスト化して取得する
-	items, err := s.itemRepo.List(ctx)
+	items, err := parseBulkAddItemsRequest(r, cmp.Or(s.maxUploadSize, defaultMaxUploadSize))
 	if err != nil {
-		slog.Error("failed to get items: ", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if errors.Is(err, errBodyTooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "request_too_large", "request body is too large")
+			return
+		}
+		var bulkErr *bulkRequestError
+		if errors.As(err, &bulkErr) {
+			writeJSON(w, http.StatusBadRequest, BulkErrorResponse{
+				ErrorResponse: ErrorResponse{Code: bulkErr.Code, Message: fmt.Sprintf("item %d: %s", bulkErr.Index, bulkErr.Message)},
+				Index:         bulkErr.Index,
+			})
+			return
+		}
+		writeBadRequest(w, err)
 		return
 	}
+	for _, item := range items {
+		item.SellerID = sellerIDFromContext(ctx)
+	}
 
-	resp := GetItemsResponse{Items: items}
-	err = json.NewEncoder(w).Encode(resp)
+	ids, err := s.itemRepo.InsertBatch(ctx, items)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		var batchErr *batchError
+		if errors.As(err, &batchErr) && errors.Is(err, errDuplicateItem) {
+			writeJSON(w, http.StatusConflict, BulkErrorResponse{
+				ErrorResponse: ErrorResponse{Code: "duplicate_item", Message: fmt.Sprintf("item %d already exists: id %d", batchErr.Index, batchErr.ID)},
+				Index:         batchErr.Index,
+				ID:            batchErr.ID,
+			})
+			return
+		}
+		slog.ErrorContext(ctx, "failed to store items: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
 		return
 	}
+
+	slog.InfoContext(ctx, fmt.Sprintf("items received: %d", len(ids)))
+	writeJSON(w, http.StatusCreated, BulkAddItemsResponse{IDs: ids})
 }
 
-// GetAnItem is a handler to return an "one" itemdata that have requested item_id for GET /items/{id}
-func (s *Handlers) GetAnItem(w http.ResponseWriter, r *http.Request) {
-	//http.Request に関連付けられたコンテキストオブジェクト(処理落ち、タイムアウトなど)を取得
-	ctx := r.Context()
+// ImportItemsError describes a row of an imported CSV which was skipped.
+type ImportItemsError struct {
+	Line    int    `json:"line"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
 
-	//URL内に含まれるidを取得
-	pid := r.PathValue("id") //リクエストのURL内に含まれているデータを見るときはPathValueを使う
-	if pid == "" {
-		http.Error(w, "id is required", http.StatusBadRequest)
-		return
+type ImportItemsResponse struct {
+	Inserted int                `json:"inserted"`
+	Skipped  int                `json:"skipped"`
+	Errors   []ImportItemsError `json:"errors"`
+}
+
+// importRow is a valid row of an imported CSV.
+type importRow struct {
+	line int
+	item *Item
+}
+
+// openImportCSV returns the CSV of a request to import items.
+// The CSV is either the "file" field of multipart/form-data or the text/csv body itself.
+// Bodies larger than maxSize bytes are rejected with errBodyTooLarge.
+func openImportCSV(r *http.Request, maxSize int64) (io.ReadCloser, error) {
+	r.Body = http.MaxBytesReader(nil, r.Body, maxSize)
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/csv" {
+		return r.Body, nil
 	}
 
-	//取得したidをintに変換
-	id, err := strconv.Atoi(pid) //文字列を整数に変換する関数（strconv）
+	file, _, err := r.FormFile("file")
 	if err != nil {
-		http.Error(w, "id must be an int", http.StatusBadRequest)
-		return
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, errBodyTooLarge
+		}
+		return nil, &requestError{Code: "file_required", Message: "csv file is required"}
 	}
+	return file, nil
+}
 
-	//idからデータを取得する
-	item, err := s.itemRepo.Select(ctx, id)
+// parseImportCSV reads the items of a CSV with the columns name,category,price.
+// Rows with missing or invalid fields are returned as errors instead of items.
+func parseImportCSV(body io.Reader) ([]importRow, []ImportItemsError, error) {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
 	if err != nil {
-		if errors.Is(err, errItemNotFound) {
-			http.Error(w, "item not found", http.StatusNotFound)
-			return
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, nil, errBodyTooLarge
 		}
-		slog.Error("failed to get item: ", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, nil, &requestError{Code: "invalid_csv", Message: fmt.Sprintf("failed to read csv header: %v", err)}
+	}
+	columns := map[string]int{}
+	for idx, name := range header {
+		columns[strings.TrimSpace(name)] = idx
+	}
+	for _, name := range []string{"name", "category", "price"} {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, &requestError{Code: "invalid_csv", Message: fmt.Sprintf("csv must have a %s column", name)}
+		}
+	}
+	field := func(record []string, name string) string {
+		if idx := columns[name]; idx < len(record) {
+			return strings.TrimSpace(record[idx])
+		}
+		return ""
 	}
 
-	err = json.NewEncoder(w).Encode(item)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	var rows []importRow
+	rowErrs := []ImportItemsError{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, nil, errBodyTooLarge
+			}
+			return nil, nil, &requestError{Code: "invalid_csv", Message: fmt.Sprintf("failed to read csv: %v", err)}
+		}
+		line, _ := cr.FieldPos(0)
+
+		name, nameErr := normalizeName("name", field(record, "name"))
+		category, categoryErr := normalizeName("category", field(record, "category"))
+		item := &Item{Name: name, Category: category}
+		price, priceErr := strconv.Atoi(field(record, "price"))
+		switch {
+		case nameErr != nil:
+			rowErrs = append(rowErrs, ImportItemsError{Line: line, Code: nameErr.Code, Message: nameErr.Message})
+		case categoryErr != nil:
+			rowErrs = append(rowErrs, ImportItemsError{Line: line, Code: categoryErr.Code, Message: categoryErr.Message})
+		case field(record, "price") == "":
+			rowErrs = append(rowErrs, ImportItemsError{Line: line, Code: "price_required", Message: "price is required"})
+		case priceErr != nil || price < 0:
+			rowErrs = append(rowErrs, ImportItemsError{Line: line, Code: "invalid_price", Message: "price must be an int of 0 or greater"})
+		default:
+			item.Price = price
+			item.Stock = defaultStock
+			rows = append(rows, importRow{line: line, item: item})
+		}
 	}
-}
 
-type AddItemRequest struct {
-	Name     string `form:"name"`
-	Category string `form:"category"` // STEP 4-2: add a category field //<-Done
-	Image    []byte `form:"image"`    // STEP 4-4: add an image field //画像はbyteに変換して保存する
+	return rows, rowErrs, nil
 }
 
-type AddItemResponse struct {
-	Message string `json:"message"`
-}
+// ImportItems is a handler to add items from a CSV for POST /items/import .
+// Invalid and duplicate rows are skipped and reported, and the other rows are added at once.
+func (s *Handlers) ImportItems(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 
-// parseAddItemRequest parses and validates the request to add an item.
-func parseAddItemRequest(r *http.Request) (*AddItemRequest, error) {
-	req := &AddItemRequest{
-		Name:     r.FormValue("name"),
-		Category: r.FormValue("category"), // STEP 4-2: add a category field // <- Done
+	body, err := openImportCSV(r, cmp.Or(s.maxUploadSize, defaultMaxUploadSize))
+	if errors.Is(err, errBodyTooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, "request_too_large", "request body is too large")
+		return
+	}
+	if err != nil {
+		writeBadRequest(w, err)
+		return
 	}
+	defer body.Close()
 
-	// STEP 4-4: add an image field
-	uploadedFile, _, err := r.FormFile("image")
+	rows, rowErrs, err := parseImportCSV(body)
+	if errors.Is(err, errBodyTooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, "request_too_large", "request body is too large")
+		return
+	}
 	if err != nil {
-		return nil, errors.New("image is required")
+		writeBadRequest(w, err)
+		return
 	}
-	defer uploadedFile.Close()
+	for _, row := range rows {
+		row.item.SellerID = sellerIDFromContext(ctx)
+	}
+
+	//重複した行を取り除きながら、残りの行をまとめて保存する
+	inserted := 0
+	for len(rows) > 0 {
+		items := make([]*Item, len(rows))
+		for idx, row := range rows {
+			items[idx] = row.item
+		}
+
+		ids, err := s.itemRepo.InsertBatch(ctx, items)
+		var batchErr *batchError
+		if errors.As(err, &batchErr) && errors.Is(err, errDuplicateItem) {
+			rowErrs = append(rowErrs, ImportItemsError{
+				Line:    rows[batchErr.Index].line,
+				Code:    "duplicate_item",
+				Message: fmt.Sprintf("item already exists: id %d", batchErr.ID),
+			})
+			rows = slices.Delete(rows, batchErr.Index, batchErr.Index+1)
+			continue
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to store items: ", "error", err)
+			writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		inserted = len(ids)
+		break
+	}
+
+	slices.SortFunc(rowErrs, func(a, b ImportItemsError) int { return cmp.Compare(a.Line, b.Line) })
+
+	slog.InfoContext(ctx, fmt.Sprintf("items imported: %d", inserted), "skipped", len(rowErrs))
+	writeJSON(w, http.StatusOK, ImportItemsResponse{Inserted: inserted, Skipped: len(rowErrs), Errors: rowErrs})
+}
 
-	imageData, err := io.ReadAll(uploadedFile)
+type UpdateItemRequest struct {
+	ID       int    // path value
+	Name     string `form:"name"`
+	Category string `form:"category"`
+	Version  int    `form:"version"` // or the If-Match header, and 0 for If-Match: *
+}
+
+// parseUpdateItemRequest parses and validates the request to update an item.
+func parseUpdateItemRequest(r *http.Request) (*UpdateItemRequest, error) {
+	id, err := parseItemID(r)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read image file: %w", err)
+		return nil, err
 	}
 
-	req.Image = imageData
+	req := &UpdateItemRequest{
+		ID:       id,
+		Name:     r.FormValue("name"),
+		Category: r.FormValue("category"),
+	}
 
 	// validate the request
-	if req.Name == "" {
-		return nil, errors.New("name is required")
+	var nameErr *requestError
+	if req.Name, nameErr = normalizeName("name", req.Name); nameErr != nil {
+		return nil, nameErr
 	}
 
-	if req.Category == "" { // STEP 4-2: validate the category field //<- Done
-		return nil, errors.New("category is required")
+	if req.Category, nameErr = normalizeName("category", req.Category); nameErr != nil {
+		return nil, nameErr
 	}
 
-	if len(req.Image) == 0 { // STEP 4-4: validate the image field //<-DOne
-		return nil, errors.New("Uploaded image is empty")
+	//クライアントが読み込んだときのversionは If-Match ヘッダ(ETag)かフォームで受け取る
+	//If-Match: * はどのversionでもよいので、versionを確認せずに更新する
+	version := r.FormValue("version")
+	if ifMatch := strings.TrimSpace(r.Header.Get("If-Match")); ifMatch == "*" {
+		return req, nil
+	} else if ifMatch != "" {
+		version = strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`)
+	}
+	if version == "" {
+		return nil, &requestError{Code: "version_required", Message: "version is required as If-Match or a form value"}
+	}
+	req.Version, err = strconv.Atoi(version)
+	if err != nil || req.Version <= 0 {
+		return nil, &requestError{Code: "invalid_version", Message: "version must be a positive integer"}
 	}
 
 	return req, nil
 }
 
-// AddItem is a handler to add a new item for POST /items .
-func (s *Handlers) AddItem(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+// itemsETag returns a weak ETag of a list of items, made from the number of items and the latest updated_at.
+// Every change to an item updates its updated_at, and deleting an item changes the number,
+// so the ETag changes whenever the list does.
+func itemsETag(items []*Item) string {
+	var latest time.Time
+	for _, item := range items {
+		if item.UpdatedAt.After(latest) {
+			latest = item.UpdatedAt
+		}
+	}
 
-	req, err := parseAddItemRequest(r)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	sum := sha256.Sum256(fmt.Appendf(nil, "%d:%d", len(items), latest.UnixNano()))
+	return `W/"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// etagMatches reports whether the If-None-Match header contains etag, using the weak comparison.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
 	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
 
-	// STEP 4-4: uncomment on adding an implementation to store an image //ファイル名をハッシュ化
-	fileName, err := s.storeImage(req.Image)
+// itemETag returns the ETag of item, which is its version.
+func itemETag(item *Item) string {
+	return fmt.Sprintf("%q", strconv.Itoa(item.Version))
+}
+
+// UpdateItem is a handler to update the name and category of an item for PUT /items/{id} .
+// The client must send the version of the item it read, and it returns 409 if the item has changed since.
+// If-Match: * updates any version of the item, but it still returns 404 if there is no item.
+// It also returns 409 with the existing item's id if another item has the new name and category.
+func (s *Handlers) UpdateItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req, err := parseUpdateItemRequest(r)
 	if err != nil {
-		slog.Error("failed to store image: ", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeBadRequest(w, err)
 		return
 	}
 
 	item := &Item{
+		ID:       req.ID,
 		Name:     req.Name,
-		Category: req.Category, // STEP 4-2: add a category field //<-Done
-		// STEP 4-4: add an image field
-		ImageName: fileName,
+		Category: req.Category,
+		Version:  req.Version,
 	}
-	message := fmt.Sprintf("item received: %s", item.Name)
-	slog.Info(message)
-
-	// STEP 4-2: add an implementation to store an item
-	err = s.itemRepo.Insert(ctx, item)
+	err = s.itemRepo.Update(ctx, item)
 	if err != nil {
-		slog.Error("failed to store item: ", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if errors.Is(err, errItemNotFound) {
+			writeError(w, http.StatusNotFound, "item_not_found", "item not found")
+			return
+		}
+		if errors.Is(err, errVersionConflict) {
+			writeError(w, http.StatusConflict, "version_conflict", "item has been changed by another request")
+			return
+		}
+		var dupErr *duplicateItemError
+		if errors.As(err, &dupErr) {
+			writeJSON(w, http.StatusConflict, UpdateDuplicateItemResponse{
+				ErrorResponse: ErrorResponse{Code: "duplicate_item", Message: fmt.Sprintf("item already exists: id %d", dupErr.ID)},
+				ExistingID:    dupErr.ID,
+			})
+			return
+		}
+		slog.ErrorContext(ctx, "failed to update item: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
 		return
 	}
 
-	resp := AddItemResponse{Message: message}
-	err = json.NewEncoder(w).Encode(resp)
+	//更新後のitemを返す
+	item, err = s.itemRepo.Select(ctx, req.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "failed to get item: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
 		return
 	}
+
+	w.Header().Set("ETag", itemETag(item))
+	writeJSON(w, http.StatusOK, item)
+}
+
+// imageExtensions maps the content types of supported uploads to the file extensions they are stored with.
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// imageContentTypes maps the file extensions of servable images to their content types.
+var imageContentTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".webp": "image/webp",
 }
 
-// storeImage stores an image and returns the file path and an error if any.
+// storeImage stores an uploaded image and returns the file name and an error if any.
 // this method calculates the hash sum of the image as a file name to avoid the duplication of a same file
 // and stores it in the image directory.
-func (s *Handlers) storeImage(image []byte) (filePath string, err error) {
+// The file extension is chosen from the detected content type of the image.
+func (s *Handlers) storeImage(img *uploadedImage) (filePath string, err error) {
 	// STEP 4-4: add an implementation to store an image
 	// TODO:
 	// - calc hash sum
@@ -248,27 +2676,89 @@ func (s *Handlers) storeImage(image []byte) (filePath string, err error) {
 	// - store image
 	// - return the image file path
 
-	//画像をハッシュの文字列にする
-	hash := sha256.Sum256(image)
-	hashStr := hex.EncodeToString(hash[:])
+	//拡張子は中身(マジックバイト)から判定する
+	contentType, err := img.contentType()
+	if err != nil {
+		return "", fmt.Errorf("failed to read image: %w", err)
+	}
+	ext, ok := imageExtensions[contentType]
+	if !ok {
+		return "", errUnsupportedImageType
+	}
+
+	//同じ画像が同時にアップロードされたときは、1つのgoroutineだけが保存し、他はその結果を使う
+	v, err, _ := s.imageGroup.Do(img.sum, func() (any, error) {
+		return s.writeImage(img, ext)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	//ファイル名を返す
+	return v.(string), nil
+}
+
+// writeImage cleans the image and stores it as <hash><ext>, where hash is the SHA-256 of the cleaned image.
+// It returns the file name.
+func (s *Handlers) writeImage(img *uploadedImage, ext string) (string, error) {
+	//WebPは再エンコードしないので、アップロードされた一時ファイルをそのままハッシュの名前にする
+	if ext == ".webp" {
+		return s.moveImage(img.path, img.sum+ext)
+	}
+
+	//EXIFなどのメタデータを取り除き、大きすぎる画像は縮小してから保存する
+	maxImageSize := s.maxImageSize
+	if maxImageSize <= 0 {
+		maxImageSize = defaultMaxImageSize
+	}
+
+	src, err := os.Open(img.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read image: %w", err)
+	}
+	defer src.Close()
+
+	//一時ファイルに書き込みながら同時にハッシュを計算し、最後にハッシュの名前にrenameする
+	tmp, err := os.CreateTemp(s.imgDirPath, "upload-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once the file has been renamed
+
+	hasher := sha256.New()
+	if err := writeCleanImage(io.MultiWriter(tmp, hasher), src, maxImageSize); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to store image: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to store image: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to store image: %w", err)
+	}
+
+	//ハッシュ化したものからファイルパスをつくる(メタデータを取り除いた後の画像から計算する)
+	return s.moveImage(tmp.Name(), hex.EncodeToString(hasher.Sum(nil))+ext)
+}
 
-	//ハッシュ化したものからファイルパスをつくる
-	fileName := fmt.Sprintf("%s.jpg", hashStr)
-	filePath = filepath.Join(s.imgDirPath, fileName)
+// moveImage renames the temporary file at tmpPath to fileName in the image directory,
+// unless the image is already stored. It returns fileName.
+func (s *Handlers) moveImage(tmpPath, fileName string) (string, error) {
+	filePath := filepath.Join(s.imgDirPath, fileName)
 
-	//jsonに保存、2重に保存しないように
+	//2重に保存しないように
 	if _, err := os.Stat(filePath); err == nil {
-		return filePath, nil
+		return fileName, nil
 	} else if !os.IsNotExist(err) {
 		return "", fmt.Errorf("error checking image existance: %w", err)
 	}
 
 	//画像を保存
-	if err := StoreImage(filePath, image); err != nil {
+	if err := os.Rename(tmpPath, filePath); err != nil {
 		return "", fmt.Errorf("failed to store image: %w", err)
 	}
 
-	//ファイル名を返す
 	return fileName, nil
 }
 
@@ -284,37 +2774,156 @@ func parseGetImageRequest(r *http.Request) (*GetImageRequest, error) {
 
 	// validate the request
 	if req.FileName == "" {
-		return nil, errors.New("filename is required")
+		return nil, &requestError{Code: "filename_required", Message: "filename is required"}
 	}
 
 	return req, nil
 }
 
+// defaultImageName is the file name of the default image returned for missing images.
+const defaultImageName = "default.jpg"
+
 // GetImage is a handler to return an image for GET /images/{filename} .
 // If the specified image is not found, it returns the default image.
 func (s *Handlers) GetImage(w http.ResponseWriter, r *http.Request) {
 	req, err := parseGetImageRequest(r)
 	if err != nil {
-		slog.Warn("failed to parse get image request: ", "error", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "failed to parse get image request: ", "error", err)
+		writeBadRequest(w, err)
 		return
 	}
 
-	imgPath, err := s.buildImagePath(req.FileName)
+	imgPath, found, err := s.resolveImagePath(req.FileName)
 	if err != nil {
-		if !errors.Is(err, errImageNotFound) {
-			slog.Warn("failed to build image path: ", "error", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "failed to build image path: ", "error", err)
+		writeBadRequest(w, err)
+		return
+	}
+
+	// images are served with http.ServeFile, which also answers Range requests with 206 Partial Content.
+	// image file names are content hashes, so stored images never change and can be cached forever.
+	// http.ServeFile answers If-None-Match with 304 Not Modified using the ETag set here.
+	// the default image must not be cached, since the real image may appear later under the same name.
+	if found {
+		name := filepath.Base(imgPath)
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Set("ETag", fmt.Sprintf("%q", strings.TrimSuffix(name, filepath.Ext(name))))
+	} else {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	slog.InfoContext(r.Context(), "returned image", "path", imgPath)
+	w.Header().Set("Content-Type", imageContentTypes[filepath.Ext(imgPath)])
+	http.ServeFile(w, r, imgPath)
+}
+
+// GetThumbnail is a handler to return a thumbnail of an image for GET /images/{filename}/thumb .
+// Thumbnails are generated on the first request and cached next to the original image, one file per format.
+// If the Accept header allows image/webp, the WebP thumbnail is returned when it is smaller than the JPEG one.
+// If the specified image is not found, it returns a thumbnail of the default image.
+// Thumbnails themselves have no thumbnail and return 404 Not Found.
+// Like GetImage, it serves files with http.ServeFile so that Range requests are honored.
+func (s *Handlers) GetThumbnail(w http.ResponseWriter, r *http.Request) {
+	req, err := parseGetImageRequest(r)
+	if err != nil {
+		slog.WarnContext(r.Context(), "failed to parse get image request: ", "error", err)
+		writeBadRequest(w, err)
+		return
+	}
+	//サムネイルのサムネイルを作ると、ファイルが際限なく増えてしまう
+	if isThumbnail(req.FileName) {
+		writeError(w, http.StatusNotFound, "image_not_found", "thumbnails have no thumbnail")
+		return
+	}
+
+	imgPath, found, err := s.resolveImagePath(req.FileName)
+	if err != nil {
+		slog.WarnContext(r.Context(), "failed to build image path: ", "error", err)
+		writeBadRequest(w, err)
+		return
+	}
+	if !found {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	thumbPath, contentType := thumbnailPath(imgPath, ".jpg"), "image/jpeg"
+	if err := ensureThumbnail(imgPath, thumbPath); err != nil {
+		// images which cannot be decoded are returned at full size
+		if errors.Is(err, image.ErrFormat) {
+			slog.DebugContext(r.Context(), "thumbnail not supported", "path", imgPath)
+			w.Header().Set("Content-Type", imageContentTypes[filepath.Ext(imgPath)])
+			http.ServeFile(w, r, imgPath)
 			return
 		}
+		slog.ErrorContext(r.Context(), "failed to create thumbnail: ", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	w.Header().Add("Vary", "Accept")
+	if acceptsWebP(r.Header.Get("Accept")) {
+		webpPath := thumbnailPath(imgPath, ".webp")
+		if err := ensureThumbnail(imgPath, webpPath); err != nil {
+			//WebPが作れなくてもJPEGは返せる
+			slog.WarnContext(r.Context(), "failed to create webp thumbnail: ", "error", err)
+		} else if smallerFile(webpPath, thumbPath) {
+			//可逆圧縮のWebPは写真だとJPEGより大きくなるので、小さいときだけ使う
+			thumbPath, contentType = webpPath, "image/webp"
+		}
+	}
+
+	slog.InfoContext(r.Context(), "returned thumbnail", "path", thumbPath)
+	w.Header().Set("Content-Type", contentType)
+	http.ServeFile(w, r, thumbPath)
+}
+
+// acceptsWebP reports whether the Accept header explicitly allows image/webp.
+// Wildcards such as image/* are not enough, since some clients send them without supporting WebP.
+func acceptsWebP(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || mediaType != "image/webp" {
+			continue
+		}
+		q, err := strconv.ParseFloat(cmp.Or(params["q"], "1"), 64)
+		return err == nil && q > 0
+	}
+	return false
+}
+
+// smallerFile reports whether the file at path is smaller than the file at other.
+func smallerFile(path, other string) bool {
+	a, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	b, err := os.Stat(other)
+	if err != nil {
+		return false
+	}
+	return a.Size() < b.Size()
+}
+
+// resolveImagePath builds and validates the image path like buildImagePath,
+// falling back to the default image when the image is not found.
+// found reports whether the requested image exists.
+func (s *Handlers) resolveImagePath(imageFileName string) (imgPath string, found bool, err error) {
+	imgPath, err = s.buildImagePath(imageFileName)
+	if err != nil {
+		if !errors.Is(err, errImageNotFound) {
+			return "", false, err
+		}
 
 		// when the image is not found, it returns the default image without an error.
 		slog.Debug("image not found", "filename", imgPath)
-		imgPath = filepath.Join(s.imgDirPath, "default.jpg")
+		defaultImage := s.defaultImage
+		if defaultImage == "" {
+			defaultImage = defaultImageName
+		}
+		return filepath.Join(s.imgDirPath, defaultImage), false, nil
 	}
 
-	slog.Info("returned image", "path", imgPath)
-	http.ServeFile(w, r, imgPath)
+	return imgPath, true, nil
 }
 
 // buildImagePath builds the image path and validates it.
@@ -324,12 +2933,12 @@ func (s *Handlers) buildImagePath(imageFileName string) (string, error) {
 	// to prevent directory traversal attacks
 	rel, err := filepath.Rel(s.imgDirPath, imgPath)
 	if err != nil || strings.HasPrefix(rel, "..") {
-		return "", fmt.Errorf("invalid image path: %s", imgPath)
+		return "", &requestError{Code: "invalid_image_path", Message: fmt.Sprintf("invalid image path: %s", imgPath)}
 	}
 
 	// validate the image suffix
-	if !strings.HasSuffix(imgPath, ".jpg") && !strings.HasSuffix(imgPath, ".jpeg") {
-		return "", fmt.Errorf("image path does not end with .jpg or .jpeg: %s", imgPath)
+	if _, ok := imageContentTypes[filepath.Ext(imgPath)]; !ok {
+		return "", &requestError{Code: "invalid_image_path", Message: fmt.Sprintf("unsupported image extension: %s", imgPath)}
 	}
 
 	// check if the image exists
@@ -1,48 +1,4007 @@
 package app
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"go.uber.org/mock/gomock"
 )
 
+// newTestImage returns a small JPEG image for upload tests.
+func newTestImage(t *testing.T) []byte {
+	t.Helper()
+
+	return newTestImageOfSize(t, 1, 1)
+}
+
+// newTestImageOfSize returns a JPEG image with the given width and height.
+func newTestImageOfSize(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// newTestPNG returns a small PNG image for upload tests.
+func newTestPNG(t *testing.T) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// withEXIF inserts an EXIF (APP1) segment with the given orientation tag into a JPEG image.
+func withEXIF(t *testing.T, img []byte, orientation uint16) []byte {
+	t.Helper()
+
+	// little-endian TIFF header followed by an IFD with the orientation tag only
+	tiff := []byte{'I', 'I', 0x2A, 0x00, 0x08, 0x00, 0x00, 0x00}
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 3) // SHORT
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0x00, 0x00)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 0) // no next IFD
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	// insert the segment right after SOI
+	return slices.Concat(img[:2], segment, img[2:])
+}
+
+// newMultipartRequest builds a multipart/form-data request with the given fields and image.
+func newMultipartRequest(t testing.TB, method, target string, fields map[string]string, img []byte) *http.Request {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			t.Fatalf("failed to write field: %v", err)
+		}
+	}
+	if img != nil {
+		fw, err := mw.CreateFormFile("image", "image.jpg")
+		if err != nil {
+			t.Fatalf("failed to create form file: %v", err)
+		}
+		if _, err := fw.Write(img); err != nil {
+			t.Fatalf("failed to write image: %v", err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("failed to close multipart writer: %v", err)
+	}
+
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+// ignoreUploadedImages ignores the images of AddItemRequest, whose contents are compared with uploadedContent.
+var ignoreUploadedImages = cmpopts.IgnoreFields(AddItemRequest{}, "Image", "ExtraImages")
+
+// uploadedContent returns the content of the spooled image, or nil if img is nil.
+func uploadedContent(t *testing.T, img *uploadedImage) []byte {
+	t.Helper()
+
+	if img == nil {
+		return nil
+	}
+	content, err := os.ReadFile(img.path)
+	if err != nil {
+		t.Fatalf("failed to read uploaded image: %v", err)
+	}
+	return content
+}
+
+// storeImageBytes spools img like an upload and stores it with h.storeImage.
+func storeImageBytes(h *Handlers, img []byte) (string, error) {
+	upload, err := spoolImage(h.imgDirPath, bytes.NewReader(img), int64(len(img)))
+	if err != nil {
+		return "", err
+	}
+	defer upload.remove()
+
+	return h.storeImage(upload)
+}
+
 func TestParseAddItemRequest(t *testing.T) {
 	t.Parallel()
 
-	type wants struct {
-		req *AddItemRequest
-		err bool
+	img := newTestImage(t)
+
+	type wants struct {
+		req *AddItemRequest
+		err bool
+	}
+
+	// STEP 6-1: define test cases
+	cases := map[string]struct {
+		args  map[string]string
+		image []byte
+		wants
+	}{
+		"ok: valid request": {
+			args: map[string]string{
+				"name":     "jacket",
+				"category": "fashion",
+				"price":    "3000",
+			},
+			image: img,
+			wants: wants{
+				req: &AddItemRequest{
+					Name:     "jacket",
+					Category: "fashion",
+					Price:    3000,
+					Stock:    defaultStock,
+				},
+				err: false,
+			},
+		},
+		"ok: with description": {
+			args: map[string]string{
+				"name":        "jacket",
+				"category":    "fashion",
+				"price":       "3000",
+				"description": "worn only once",
+			},
+			image: img,
+			wants: wants{
+				req: &AddItemRequest{
+					Name:        "jacket",
+					Category:    "fashion",
+					Price:       3000,
+					Description: "worn only once",
+					Stock:       defaultStock,
+				},
+				err: false,
+			},
+		},
+		"ok: with tags": {
+			args: map[string]string{
+				"name":     "jacket",
+				"category": "fashion",
+				"price":    "3000",
+				"tags":     " sale, vintage,sale,",
+			},
+			image: img,
+			wants: wants{
+				req: &AddItemRequest{
+					Name:     "jacket",
+					Category: "fashion",
+					Price:    3000,
+					Tags:     []string{"sale", "vintage"},
+					Stock:    defaultStock,
+				},
+				err: false,
+			},
+		},
+		"ok: name and category are trimmed": {
+			args: map[string]string{
+				"name":     "  jacket\t",
+				"category": " fashion ",
+				"price":    "3000",
+			},
+			image: img,
+			wants: wants{
+				req: &AddItemRequest{
+					Name:     "jacket",
+					Category: "fashion",
+					Price:    3000,
+					Stock:    defaultStock,
+				},
+				err: false,
+			},
+		},
+		"ok: name of max length": {
+			args: map[string]string{
+				"name":     strings.Repeat("あ", maxNameLength),
+				"category": "fashion",
+				"price":    "3000",
+			},
+			image: img,
+			wants: wants{
+				req: &AddItemRequest{
+					Name:     strings.Repeat("あ", maxNameLength),
+					Category: "fashion",
+					Price:    3000,
+					Stock:    defaultStock,
+				},
+				err: false,
+			},
+		},
+		"ng: whitespace-only name": {
+			args: map[string]string{
+				"name":     "   ",
+				"category": "fashion",
+				"price":    "3000",
+			},
+			image: img,
+			wants: wants{
+				req: nil,
+				err: true,
+			},
+		},
+		"ng: whitespace-only category": {
+			args: map[string]string{
+				"name":     "jacket",
+				"category": "\t",
+				"price":    "3000",
+			},
+			image: img,
+			wants: wants{
+				req: nil,
+				err: true,
+			},
+		},
+		"ng: name too long": {
+			args: map[string]string{
+				"name":     strings.Repeat("a", maxNameLength+1),
+				"category": "fashion",
+				"price":    "3000",
+			},
+			image: img,
+			wants: wants{
+				req: nil,
+				err: true,
+			},
+		},
+		"ng: category too long": {
+			args: map[string]string{
+				"name":     "jacket",
+				"category": strings.Repeat("a", maxNameLength+1),
+				"price":    "3000",
+			},
+			image: img,
+			wants: wants{
+				req: nil,
+				err: true,
+			},
+		},
+		"ng: negative stock": {
+			args: map[string]string{
+				"name":     "jacket",
+				"category": "fashion",
+				"price":    "3000",
+				"stock":    "-1",
+			},
+			image: img,
+			wants: wants{
+				req: nil,
+				err: true,
+			},
+		},
+		"ng: too many tags": {
+			args: map[string]string{
+				"name":     "jacket",
+				"category": "fashion",
+				"price":    "3000",
+				"tags":     "a,b,c,d,e,f,g,h,i,j,k",
+			},
+			image: img,
+			wants: wants{
+				req: nil,
+				err: true,
+			},
+		},
+		"ng: description too long": {
+			args: map[string]string{
+				"name":        "jacket",
+				"category":    "fashion",
+				"price":       "3000",
+				"description": strings.Repeat("a", maxDescriptionLength+1),
+			},
+			image: img,
+			wants: wants{
+				req: nil,
+				err: true,
+			},
+		},
+		"ng: not an image": {
+			args: map[string]string{
+				"name":     "jacket",
+				"category": "fashion",
+				"price":    "3000",
+			},
+			image: []byte("this is not an image"),
+			wants: wants{
+				req: nil,
+				err: true,
+			},
+		},
+		"ng: missing price": {
+			args: map[string]string{
+				"name":     "jacket",
+				"category": "fashion",
+			},
+			image: img,
+			wants: wants{
+				req: nil,
+				err: true,
+			},
+		},
+		"ng: negative price": {
+			args: map[string]string{
+				"name":     "jacket",
+				"category": "fashion",
+				"price":    "-1",
+			},
+			image: img,
+			wants: wants{
+				req: nil,
+				err: true,
+			},
+		},
+		"ng: empty request": {
+			args: map[string]string{},
+			wants: wants{
+				req: nil,
+				err: true,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// prepare HTTP request
+			req := newMultipartRequest(t, "POST", "http://localhost:9000/items", tt.args, tt.image)
+			imgDir := t.TempDir()
+
+			// execute test target
+			got, err := parseAddItemRequest(req, imgDir, defaultMaxUploadSize, defaultMultipartMemory)
+
+			// confirm the result
+			if err != nil {
+				if !tt.err {
+					t.Errorf("unexpected error: %v", err)
+				}
+				// the spooled images are removed on errors
+				if entries, err := os.ReadDir(imgDir); err != nil || len(entries) != 0 {
+					t.Errorf("expected no spooled images, got %v, %v", entries, err)
+				}
+				return
+			}
+			if tt.err {
+				t.Errorf("expected an error, got nil")
+			}
+			if diff := cmp.Diff(tt.wants.req, got, ignoreUploadedImages); diff != "" {
+				t.Errorf("unexpected request (-want +got):\n%s", diff)
+			}
+			if content := uploadedContent(t, got.Image); !bytes.Equal(content, tt.image) {
+				t.Errorf("expected the uploaded image, got %d bytes", len(content))
+			}
+		})
+	}
+}
+
+func TestParseAddItemRequestJSON(t *testing.T) {
+	t.Parallel()
+
+	img := newTestImage(t)
+
+	type wants struct {
+		req *AddItemRequest
+		err error // nil means no error; errAny means any error
+	}
+	errAny := errors.New("any error")
+
+	cases := map[string]struct {
+		body          string
+		maxUploadSize int64
+		wants
+	}{
+		"ok: valid request": {
+			body: fmt.Sprintf(`{"name":"jacket","category":"fashion","price":3000,"image":%q}`, base64.StdEncoding.EncodeToString(img)),
+			wants: wants{
+				req: &AddItemRequest{
+					Name:     "jacket",
+					Category: "fashion",
+					Price:    3000,
+					Stock:    defaultStock,
+				},
+			},
+		},
+		"ng: missing image": {
+			body: `{"name":"jacket","category":"fashion","price":3000}`,
+			wants: wants{
+				err: errAny,
+			},
+		},
+		"ng: missing price": {
+			body: fmt.Sprintf(`{"name":"jacket","category":"fashion","image":%q}`, base64.StdEncoding.EncodeToString(img)),
+			wants: wants{
+				err: errAny,
+			},
+		},
+		"ng: image is not base64": {
+			body: `{"name":"jacket","category":"fashion","price":3000,"image":"not base64!"}`,
+			wants: wants{
+				err: errAny,
+			},
+		},
+		"ng: image too large": {
+			body:          fmt.Sprintf(`{"name":"jacket","category":"fashion","price":3000,"image":%q}`, base64.StdEncoding.EncodeToString(img)),
+			maxUploadSize: int64(len(img) - 1),
+			wants: wants{
+				err: errImageTooLarge,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			maxUploadSize := tt.maxUploadSize
+			if maxUploadSize == 0 {
+				maxUploadSize = defaultMaxUploadSize
+			}
+
+			req := httptest.NewRequest("POST", "/items", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			got, err := parseAddItemRequest(req, t.TempDir(), maxUploadSize, defaultMultipartMemory)
+			switch {
+			case tt.wants.err == nil && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wants.err == errAny && err == nil:
+				t.Fatalf("expected an error, got nil")
+			case tt.wants.err != nil && tt.wants.err != errAny && !errors.Is(err, tt.wants.err):
+				t.Fatalf("expected error %v, got %v", tt.wants.err, err)
+			}
+			if diff := cmp.Diff(tt.wants.req, got, ignoreUploadedImages); diff != "" {
+				t.Errorf("unexpected request (-want +got):\n%s", diff)
+			}
+			if got != nil && !bytes.Equal(uploadedContent(t, got.Image), img) {
+				t.Errorf("expected the uploaded image")
+			}
+		})
+	}
+}
+
+func TestParseAddItemRequestMultipartMemory(t *testing.T) {
+	// not parallel because it sets TMPDIR to see the temporary files of the form
+	imgDir := t.TempDir()
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+
+	img := newTestImageOfSize(t, 100, 100)
+	args := map[string]string{"name": "jacket", "category": "fashion", "price": "1000"}
+
+	cases := map[string]struct {
+		maxMemory int64
+	}{
+		"ok: image kept in memory": {
+			maxMemory: defaultMultipartMemory,
+		},
+		"ok: image spilled to a temporary file": {
+			maxMemory: 1,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := newMultipartRequest(t, "POST", "/items", args, img)
+			got, err := parseAddItemRequest(req, imgDir, defaultMaxUploadSize, tt.maxMemory)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer got.removeImages()
+			if content := uploadedContent(t, got.Image); !bytes.Equal(content, img) {
+				t.Errorf("expected the uploaded image, got %d bytes", len(content))
+			}
+
+			if entries, err := os.ReadDir(tmpDir); err != nil || len(entries) != 0 {
+				t.Errorf("expected temporary files to be removed, got %v, %v", entries, err)
+			}
+		})
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		name string
+		want slog.Level
+		err  bool
+	}{
+		"ok: default":    {name: "", want: slog.LevelInfo},
+		"ok: debug":      {name: "debug", want: slog.LevelDebug},
+		"ok: upper case": {name: "WARN", want: slog.LevelWarn},
+		"ok: error":      {name: "error", want: slog.LevelError},
+		"ng: unknown":    {name: "verbose", err: true},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseLogLevel(tt.name)
+			if (err != nil) != tt.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.err && got != tt.want {
+				t.Errorf("expected level %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHelloHandler(t *testing.T) {
+	t.Parallel()
+
+	// predefine what we want
+	type wants struct {
+		code int               // desired HTTP status code
+		body map[string]string // desired body
+	}
+	want := wants{
+		code: http.StatusOK,
+		body: map[string]string{"message": "Hello, world!"},
+	}
+
+	// set up test
+	req := httptest.NewRequest("GET", "/hello", nil)
+	res := httptest.NewRecorder()
+
+	h := &Handlers{}
+	h.Hello(res, req)
+
+	// STEP 6-2: confirm the status code
+	if res.Code != want.code {
+		t.Errorf("expected status code %d, got %d", want.code, res.Code)
+	}
+
+	if got := res.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("expected Content-Type application/json, got %s", got)
+	}
+
+	// STEP 6-2: confirm response body
+	var got map[string]string
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response body: %v", err)
+	}
+	if diff := cmp.Diff(want.body, got); diff != "" {
+		t.Errorf("unexpected response body (-want +got):\n%s", diff)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	// not parallel because it sets the build information variables
+	commit, buildTime := Commit, BuildTime
+	t.Cleanup(func() { Commit, BuildTime = commit, buildTime })
+	Commit, BuildTime = "0123abc", "2025-04-01T12:00:00Z"
+
+	h := &Handlers{}
+	req := httptest.NewRequest("GET", "/version", nil)
+	rr := httptest.NewRecorder()
+	h.Version(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	var got VersionResponse
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if got.Commit != "0123abc" || got.BuildTime != "2025-04-01T12:00:00Z" {
+		t.Errorf("expected the build information set by ldflags, got %+v", got)
+	}
+	if got.GoVersion == "" {
+		t.Errorf("expected the go version, got empty")
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code   int
+		status string
+	}
+	cases := map[string]struct {
+		imgDirPath       func(t *testing.T) string
+		minFreeDiskSpace uint64
+		injector         func(m *MockItemRepository)
+		wants
+	}{
+		"ok: enough disk space": {
+			imgDirPath:       func(t *testing.T) string { return t.TempDir() },
+			minFreeDiskSpace: 1,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Count(gomock.Any()).Return(3, nil)
+			},
+			wants: wants{
+				code:   http.StatusOK,
+				status: "ok",
+			},
+		},
+		"ok: degraded when nearly full": {
+			imgDirPath:       func(t *testing.T) string { return t.TempDir() },
+			minFreeDiskSpace: math.MaxUint64,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Count(gomock.Any()).Return(3, nil)
+			},
+			wants: wants{
+				code:   http.StatusOK,
+				status: "degraded",
+			},
+		},
+		"ng: repository is not available": {
+			imgDirPath: func(t *testing.T) string { return t.TempDir() },
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Count(gomock.Any()).Return(0, errors.New("failed to count"))
+			},
+			wants: wants{
+				code:   http.StatusServiceUnavailable,
+				status: "unavailable",
+			},
+		},
+		"ng: image directory does not exist": {
+			imgDirPath: func(t *testing.T) string { return filepath.Join(t.TempDir(), "missing") },
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Count(gomock.Any()).Return(3, nil)
+			},
+			wants: wants{
+				code:   http.StatusServiceUnavailable,
+				status: "unavailable",
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{imgDirPath: tt.imgDirPath(t), minFreeDiskSpace: tt.minFreeDiskSpace, itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", "/healthz", nil)
+			rr := httptest.NewRecorder()
+			h.Healthz(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+
+			var got HealthResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got.Status != tt.wants.status {
+				t.Errorf("expected status %q, got %q", tt.wants.status, got.Status)
+			}
+			if tt.wants.code == http.StatusOK && got.FreeBytes == nil {
+				t.Errorf("expected free_bytes, got none")
+			}
+		})
+	}
+}
+
+func TestAddItem(t *testing.T) {
+	t.Parallel()
+
+	img := newTestImage(t)
+
+	type wants struct {
+		code    int
+		errCode string
+	}
+	cases := map[string]struct {
+		args          map[string]string
+		maxUploadSize int64
+		injector      func(m *MockItemRepository)
+		wants
+	}{
+		"ok: correctly inserted": {
+			args: map[string]string{
+				"name":     "used iPhone 16e",
+				"category": "phone",
+				"price":    "50000",
+			},
+			injector: func(m *MockItemRepository) {
+				// STEP 6-3: define mock expectation
+				// succeeded to insert
+				m.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(1, nil)
+			},
+			wants: wants{
+				code: http.StatusCreated,
+			},
+		},
+		"ng: failed to insert": {
+			args: map[string]string{
+				"name":     "used iPhone 16e",
+				"category": "phone",
+				"price":    "50000",
+			},
+			injector: func(m *MockItemRepository) {
+				// STEP 6-3: define mock expectation
+				// failed to insert
+				m.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(0, errors.New("failed to insert"))
+			},
+			wants: wants{
+				code:    http.StatusInternalServerError,
+				errCode: "internal_error",
+			},
+		},
+		"ng: duplicate item": {
+			args: map[string]string{
+				"name":     "used iPhone 16e",
+				"category": "phone",
+				"price":    "50000",
+			},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(1, errDuplicateItem)
+			},
+			wants: wants{
+				code:    http.StatusConflict,
+				errCode: "duplicate_item",
+			},
+		},
+		"ng: empty name": {
+			args: map[string]string{
+				"category": "phone",
+				"price":    "50000",
+			},
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code:    http.StatusBadRequest,
+				errCode: "name_required",
+			},
+		},
+		"ng: image too large": {
+			args: map[string]string{
+				"name":     "used iPhone 16e",
+				"category": "phone",
+				"price":    "50000",
+			},
+			maxUploadSize: int64(len(img) - 1),
+			injector:      func(m *MockItemRepository) {},
+			wants: wants{
+				code:    http.StatusRequestEntityTooLarge,
+				errCode: "image_too_large",
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{imgDirPath: t.TempDir(), maxUploadSize: tt.maxUploadSize, itemRepo: mockIR}
+
+			req := newMultipartRequest(t, "POST", "/items", tt.args, img)
+
+			rr := httptest.NewRecorder()
+			h.AddItem(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.code >= 400 {
+				var got DuplicateItemResponse
+				if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+					t.Fatalf("failed to decode error response: %v", err)
+				}
+				if got.Code != tt.wants.errCode {
+					t.Errorf("expected error code %s, got %s", tt.wants.errCode, got.Code)
+				}
+				if tt.wants.code == http.StatusConflict && got.ID != 1 {
+					t.Errorf("expected existing id 1, got %d", got.ID)
+				}
+				return
+			}
+
+			if got := rr.Header().Get("Location"); got != "/items/1" {
+				t.Errorf("expected Location /items/1, got %s", got)
+			}
+			if !strings.Contains(rr.Body.String(), tt.args["name"]) {
+				t.Errorf("response body does not contain %s, got: %s", tt.args["name"], rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAddItemSellerID(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+
+	mockIR := NewMockItemRepository(ctrl)
+	mockIR.EXPECT().Insert(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, item *Item) (int, error) {
+		if item.SellerID != "seller1" {
+			t.Errorf("expected seller id seller1, got %q", item.SellerID)
+		}
+		return 1, nil
+	})
+	h := &Handlers{imgDirPath: t.TempDir(), itemRepo: mockIR}
+
+	req := newMultipartRequest(t, "POST", "/items", map[string]string{"name": "jacket", "category": "fashion", "price": "1000"}, newTestImage(t))
+	req = req.WithContext(context.WithValue(req.Context(), sellerIDKey{}, "seller1"))
+	rr := httptest.NewRecorder()
+	h.AddItem(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+	}
+}
+
+func TestAddItemLog(t *testing.T) {
+	// not parallel because it replaces the default logger
+	buf := &bytes.Buffer{}
+	defaultLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+	slog.SetDefault(slog.New(slog.NewJSONHandler(buf, nil)))
+
+	ctrl := gomock.NewController(t)
+
+	mockIR := NewMockItemRepository(ctrl)
+	mockIR.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(7, nil)
+	h := &Handlers{imgDirPath: t.TempDir(), itemRepo: mockIR}
+
+	img := newTestImage(t)
+	req := newMultipartRequest(t, "POST", "/items", map[string]string{"name": "jacket", "category": "fashion", "price": "1000"}, img)
+	rr := httptest.NewRecorder()
+	h.AddItem(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+	}
+
+	// the fields of the created item are logged as attributes
+	var record map[string]any
+	for line := range strings.Lines(buf.String()) {
+		var r map[string]any
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("failed to decode log: %v", err)
+		}
+		if r["msg"] == "item created" {
+			record = r
+		}
+	}
+	if record == nil {
+		t.Fatalf("expected an item created log, got %s", buf)
+	}
+	for key, want := range map[string]any{"id": 7.0, "name": "jacket", "category": "fashion", "price": 1000.0} {
+		if record[key] != want {
+			t.Errorf("expected %s %v, got %v", key, want, record[key])
+		}
+	}
+	if image, _ := record["image"].(string); !strings.HasSuffix(image, ".jpg") {
+		t.Errorf("expected the image file name, got %v", record["image"])
+	}
+	if strings.Contains(buf.String(), base64.StdEncoding.EncodeToString(img)) {
+		t.Errorf("expected the image bytes not to be logged")
+	}
+}
+
+func TestAddItemMultipleImages(t *testing.T) {
+	t.Parallel()
+
+	jpg := newTestImageOfSize(t, 2, 2)
+	png := newTestPNG(t)
+	args := map[string]string{"name": "jacket", "category": "fashion", "price": "1000"}
+
+	// newRequest builds a form with an image field for each of images
+	newRequest := func(t *testing.T, images ...[]byte) *http.Request {
+		t.Helper()
+
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		for k, v := range args {
+			if err := mw.WriteField(k, v); err != nil {
+				t.Fatalf("failed to write field: %v", err)
+			}
+		}
+		for idx, img := range images {
+			fw, err := mw.CreateFormFile("image", fmt.Sprintf("image%d", idx))
+			if err != nil {
+				t.Fatalf("failed to create form file: %v", err)
+			}
+			if _, err := fw.Write(img); err != nil {
+				t.Fatalf("failed to write image: %v", err)
+			}
+		}
+		if err := mw.Close(); err != nil {
+			t.Fatalf("failed to close multipart writer: %v", err)
+		}
+
+		req := httptest.NewRequest("POST", "/items", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return req
+	}
+
+	type wants struct {
+		code    int
+		errCode string
+		message string
+		images  int
+	}
+	cases := map[string]struct {
+		images [][]byte
+		wants
+	}{
+		"ok: several images": {
+			images: [][]byte{jpg, png},
+			wants: wants{
+				code:   http.StatusCreated,
+				images: 2,
+			},
+		},
+		"ok: the same image is stored once": {
+			images: [][]byte{jpg, png, jpg},
+			wants: wants{
+				code:   http.StatusCreated,
+				images: 2,
+			},
+		},
+		"ng: invalid second image": {
+			images: [][]byte{jpg, []byte("this is not an image")},
+			wants: wants{
+				code:    http.StatusBadRequest,
+				errCode: "invalid_image",
+				message: "uploaded file 2 is not a valid image",
+			},
+		},
+		"ng: too many images": {
+			images: slices.Repeat([][]byte{jpg}, maxItemImages+1),
+			wants: wants{
+				code:    http.StatusBadRequest,
+				errCode: "too_many_images",
+				message: fmt.Sprintf("at most %d images can be uploaded", maxItemImages),
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			repo := NewMemoryItemRepository()
+			h := &Handlers{imgDirPath: t.TempDir(), itemRepo: repo}
+
+			rr := httptest.NewRecorder()
+			h.AddItem(rr, newRequest(t, tt.images...))
+
+			if tt.wants.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wants.code, rr.Code, rr.Body)
+			}
+			// the uploads spooled to the image directory are not left behind
+			if tmps, err := filepath.Glob(filepath.Join(h.imgDirPath, "*.tmp")); err != nil || len(tmps) != 0 {
+				t.Errorf("expected no temporary files, got %v, %v", tmps, err)
+			}
+			if tt.wants.code != http.StatusCreated {
+				var got ErrorResponse
+				if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if got.Code != tt.wants.errCode || got.Message != tt.wants.message {
+					t.Errorf("expected error %s %q, got %s %q", tt.wants.errCode, tt.wants.message, got.Code, got.Message)
+				}
+				return
+			}
+
+			// the item returns all the images, and the first one is still its image
+			location := rr.Header().Get("Location")
+			req := httptest.NewRequest("GET", location, nil)
+			req.SetPathValue("id", strings.TrimPrefix(location, "/items/"))
+			rr = httptest.NewRecorder()
+			h.GetAnItem(rr, req)
+
+			var got struct {
+				ImageURL string   `json:"image_url"`
+				Images   []string `json:"images"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(got.Images) != tt.wants.images {
+				t.Fatalf("expected %d images, got %v", tt.wants.images, got.Images)
+			}
+			if got.ImageURL != got.Images[0] || !strings.HasSuffix(got.Images[0], ".jpg") || !strings.HasSuffix(got.Images[1], ".png") {
+				t.Errorf("expected the jpeg first and the png second, got image_url %s and images %v", got.ImageURL, got.Images)
+			}
+			for _, url := range got.Images {
+				if _, err := os.Stat(filepath.Join(h.imgDirPath, strings.TrimPrefix(url, "/images/"))); err != nil {
+					t.Errorf("expected %s to be stored: %v", url, err)
+				}
+			}
+		})
+	}
+}
+
+func TestAddItemBodyTooLarge(t *testing.T) {
+	t.Parallel()
+
+	img := newTestImage(t)
+	fields := map[string]string{"name": "jacket", "category": "fashion", "price": "1000", "description": strings.Repeat("a", 2000)}
+
+	cases := map[string]struct {
+		newRequest func(t *testing.T) *http.Request
+	}{
+		"ng: multipart": {
+			newRequest: func(t *testing.T) *http.Request {
+				return newMultipartRequest(t, "POST", "/items", fields, img)
+			},
+		},
+		"ng: json": {
+			newRequest: func(t *testing.T) *http.Request {
+				body := fmt.Sprintf(`{"name":"jacket","category":"fashion","price":1000,"description":%q,"image":%q}`, fields["description"], base64.StdEncoding.EncodeToString(img))
+				req := httptest.NewRequest("POST", "/items", strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				return req
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			h := &Handlers{imgDirPath: t.TempDir(), itemRepo: mockIR}
+
+			rr := httptest.NewRecorder()
+			maxBodySizeMiddleware(http.HandlerFunc(h.AddItem), 1024).ServeHTTP(rr, tt.newRequest(t))
+
+			if rr.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("expected status code %d, got %d", http.StatusRequestEntityTooLarge, rr.Code)
+			}
+			var got ErrorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode error response: %v", err)
+			}
+			if got.Code != "request_too_large" {
+				t.Errorf("expected error code request_too_large, got %s", got.Code)
+			}
+		})
+	}
+}
+
+func TestAddItemValidationErrors(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	h := &Handlers{imgDirPath: t.TempDir(), itemRepo: NewMockItemRepository(ctrl)}
+
+	// every invalid field is reported, not only the first one
+	args := map[string]string{
+		"name":     " ",
+		"category": "fashion",
+		"price":    "-1",
+		"stock":    "many",
+	}
+	req := newMultipartRequest(t, "POST", "/items", args, []byte("this is not an image"))
+	rr := httptest.NewRecorder()
+	h.AddItem(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	var got ValidationErrorResponse
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	want := ValidationErrorResponse{
+		ErrorResponse: ErrorResponse{Code: "name_required", Message: "name is required"},
+		Errors: []FieldError{
+			{Field: "name", Code: "name_required", Message: "name is required"},
+			{Field: "image", Code: "invalid_image", Message: "uploaded file is not a valid image"},
+			{Field: "price", Code: "invalid_price", Message: "price must be 0 or greater"},
+			{Field: "stock", Code: "invalid_stock", Message: "stock must be an int"},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected response (-want +got):\n%s", diff)
+	}
+}
+
+func TestAddItemValidateOnly(t *testing.T) {
+	t.Parallel()
+
+	img := newTestImage(t)
+	valid := map[string]string{"name": "jacket", "category": "fashion", "price": "1000"}
+	invalid := map[string]string{"name": " ", "category": "fashion", "price": "-1"}
+
+	type wants struct {
+		code   int
+		errors []string
+	}
+	cases := map[string]struct {
+		newRequest func(t *testing.T) *http.Request
+		wants
+	}{
+		"ok: valid with an image": {
+			newRequest: func(t *testing.T) *http.Request {
+				return newMultipartRequest(t, "POST", "/items?validate_only=true", valid, img)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ok: valid without an image": {
+			newRequest: func(t *testing.T) *http.Request {
+				return newMultipartRequest(t, "POST", "/items?validate_only=true", valid, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ok: valid json without an image": {
+			newRequest: func(t *testing.T) *http.Request {
+				req := httptest.NewRequest("POST", "/items?validate_only=1", strings.NewReader(`{"name":"jacket","category":"fashion","price":1000}`))
+				req.Header.Set("Content-Type", "application/json")
+				return req
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: invalid fields": {
+			newRequest: func(t *testing.T) *http.Request {
+				return newMultipartRequest(t, "POST", "/items?validate_only=true", invalid, nil)
+			},
+			wants: wants{
+				code:   http.StatusBadRequest,
+				errors: []string{"name_required", "invalid_price"},
+			},
+		},
+		"ng: invalid image": {
+			newRequest: func(t *testing.T) *http.Request {
+				return newMultipartRequest(t, "POST", "/items?validate_only=true", valid, []byte("this is not an image"))
+			},
+			wants: wants{
+				code:   http.StatusBadRequest,
+				errors: []string{"invalid_image"},
+			},
+		},
+		"ng: image required unless validating": {
+			newRequest: func(t *testing.T) *http.Request {
+				return newMultipartRequest(t, "POST", "/items?validate_only=false", valid, nil)
+			},
+			wants: wants{
+				code:   http.StatusBadRequest,
+				errors: []string{"image_required"},
+			},
+		},
+		"ng: invalid validate_only": {
+			newRequest: func(t *testing.T) *http.Request {
+				return newMultipartRequest(t, "POST", "/items?validate_only=yes", valid, img)
+			},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// no calls to the repository are expected
+			ctrl := gomock.NewController(t)
+			h := &Handlers{imgDirPath: t.TempDir(), itemRepo: NewMockItemRepository(ctrl)}
+
+			rr := httptest.NewRecorder()
+			h.AddItem(rr, tt.newRequest(t))
+
+			if tt.wants.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wants.code, rr.Code, rr.Body)
+			}
+			if tt.wants.code == http.StatusOK {
+				var got ValidateItemResponse
+				if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if !got.Valid {
+					t.Errorf("expected valid, got %+v", got)
+				}
+			} else if tt.wants.errors != nil {
+				var got ValidationErrorResponse
+				if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				var codes []string
+				for _, fe := range got.Errors {
+					codes = append(codes, fe.Code)
+				}
+				if diff := cmp.Diff(tt.wants.errors, codes); diff != "" {
+					t.Errorf("unexpected errors (-want +got):\n%s", diff)
+				}
+			}
+
+			// the image is never stored
+			if entries, err := os.ReadDir(h.imgDirPath); err != nil || len(entries) != 0 {
+				t.Errorf("expected no stored images, got %v, %v", entries, err)
+			}
+		})
+	}
+}
+
+func TestAddItemIdempotencyKey(t *testing.T) {
+	t.Parallel()
+
+	img := newTestImage(t)
+	args := map[string]string{
+		"name":     "used iPhone 16e",
+		"category": "phone",
+		"price":    "50000",
+	}
+
+	ctrl := gomock.NewController(t)
+
+	mockIR := NewMockItemRepository(ctrl)
+	gomock.InOrder(
+		// a failed request doesn't keep the key, so the retry inserts the item
+		mockIR.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(0, errors.New("failed to insert")),
+		mockIR.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(1, nil),
+	)
+	h := &Handlers{imgDirPath: t.TempDir(), idempotencyKeys: newIdempotencyStore(idempotencyKeyTTL), itemRepo: mockIR}
+
+	post := func() *httptest.ResponseRecorder {
+		req := newMultipartRequest(t, "POST", "/items", args, img)
+		req.Header.Set("Idempotency-Key", "a1b2c3")
+		rr := httptest.NewRecorder()
+		h.AddItem(rr, req)
+		return rr
+	}
+
+	if rr := post(); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status code %d, got %d", http.StatusInternalServerError, rr.Code)
+	}
+
+	first := post()
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d", http.StatusCreated, first.Code)
+	}
+
+	// the same key returns the original response without inserting again
+	second := post()
+	if second.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d", http.StatusCreated, second.Code)
+	}
+	if got := second.Header().Get("Idempotent-Replayed"); got != "true" {
+		t.Errorf("expected Idempotent-Replayed true, got %q", got)
+	}
+	if got := second.Header().Get("Location"); got != "/items/1" {
+		t.Errorf("expected Location /items/1, got %s", got)
+	}
+	if diff := cmp.Diff(first.Body.String(), second.Body.String()); diff != "" {
+		t.Errorf("unexpected replayed body (-want +got):\n%s", diff)
+	}
+}
+
+func TestAddItemIdempotencyKeyPerSeller(t *testing.T) {
+	t.Parallel()
+
+	img := newTestImage(t)
+	args := map[string]string{
+		"name":     "used iPhone 16e",
+		"category": "phone",
+		"price":    "50000",
+	}
+
+	ctrl := gomock.NewController(t)
+
+	mockIR := NewMockItemRepository(ctrl)
+	gomock.InOrder(
+		mockIR.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(1, nil),
+		mockIR.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(2, nil),
+	)
+	h := &Handlers{imgDirPath: t.TempDir(), idempotencyKeys: newIdempotencyStore(idempotencyKeyTTL), itemRepo: mockIR}
+
+	post := func(sellerID string) *httptest.ResponseRecorder {
+		req := newMultipartRequest(t, "POST", "/items", args, img)
+		req = req.WithContext(context.WithValue(req.Context(), sellerIDKey{}, sellerID))
+		req.Header.Set("Idempotency-Key", "a1b2c3")
+		rr := httptest.NewRecorder()
+		h.AddItem(rr, req)
+		return rr
+	}
+
+	if rr := post("seller1"); rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+	}
+
+	// another seller using the same key gets its own item, not the stored response
+	rr := post("seller2")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+	}
+	if got := rr.Header().Get("Idempotent-Replayed"); got != "" {
+		t.Errorf("expected no Idempotent-Replayed, got %q", got)
+	}
+	if got := rr.Header().Get("Location"); got != "/items/2" {
+		t.Errorf("expected Location /items/2, got %s", got)
+	}
+}
+
+func TestBulkAddItems(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code    int
+		ids     []int
+		index   int
+		errCode string
+	}
+	cases := map[string]struct {
+		body          string
+		maxUploadSize int64
+		injector      func(m *MockItemRepository)
+		wants
+	}{
+		"ok: correctly inserted": {
+			body: `[{"name":"jacket","category":"fashion","price":3000},{"name":"iPhone","category":"phone","price":50000}]`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().InsertBatch(gomock.Any(), gomock.Len(2)).Return([]int{1, 2}, nil)
+			},
+			wants: wants{
+				code: http.StatusCreated,
+				ids:  []int{1, 2},
+			},
+		},
+		"ng: invalid item": {
+			body:     `[{"name":"jacket","category":"fashion","price":3000},{"name":"iPhone","price":50000}]`,
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code:  http.StatusBadRequest,
+				index: 1,
+			},
+		},
+		"ng: duplicate item": {
+			body: `[{"name":"jacket","category":"fashion","price":3000},{"name":"jacket","category":"fashion","price":3000}]`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().InsertBatch(gomock.Any(), gomock.Any()).Return(nil, &batchError{Index: 1, ID: 1, Err: errDuplicateItem})
+			},
+			wants: wants{
+				code:  http.StatusConflict,
+				index: 1,
+			},
+		},
+		"ng: body larger than the upload limit": {
+			body:          `[{"name":"jacket","category":"fashion","price":3000},{"name":"iPhone","category":"phone","price":50000}]`,
+			maxUploadSize: 32,
+			injector:      func(m *MockItemRepository) {},
+			wants: wants{
+				code:    http.StatusRequestEntityTooLarge,
+				errCode: "request_too_large",
+			},
+		},
+		"ng: empty batch": {
+			body:     `[]`,
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{maxUploadSize: tt.maxUploadSize, itemRepo: mockIR}
+
+			req := httptest.NewRequest("POST", "/items/bulk", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			h.BulkAddItems(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.code >= 400 {
+				var got BulkErrorResponse
+				if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+					t.Fatalf("failed to decode error response: %v", err)
+				}
+				if got.Index != tt.wants.index {
+					t.Errorf("expected index %d, got %d", tt.wants.index, got.Index)
+				}
+				if tt.wants.errCode != "" && got.Code != tt.wants.errCode {
+					t.Errorf("expected error code %s, got %s", tt.wants.errCode, got.Code)
+				}
+				return
+			}
+
+			var got BulkAddItemsResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if diff := cmp.Diff(tt.wants.ids, got.IDs); diff != "" {
+				t.Errorf("unexpected ids (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestImportItems(t *testing.T) {
+	t.Parallel()
+
+	body := "name,category,price\n" +
+		"jacket,fashion,3000\n" +
+		",phone,50000\n" +
+		"coat,fashion,abc\n" +
+		"iPhone,phone,50000\n" +
+		"shoes,fashion,8000\n"
+
+	ctrl := gomock.NewController(t)
+
+	mockIR := NewMockItemRepository(ctrl)
+	gomock.InOrder(
+		// jacket already exists, so it is dropped and the rest is inserted again
+		mockIR.EXPECT().InsertBatch(gomock.Any(), gomock.Len(3)).Return(nil, &batchError{Index: 0, ID: 1, Err: errDuplicateItem}),
+		mockIR.EXPECT().InsertBatch(gomock.Any(), gomock.Len(2)).Return([]int{2, 3}, nil),
+	)
+	h := &Handlers{itemRepo: mockIR}
+
+	req := httptest.NewRequest("POST", "/items/import", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+	h.ImportItems(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var got ImportItemsResponse
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	want := ImportItemsResponse{
+		Inserted: 2,
+		Skipped:  3,
+		Errors: []ImportItemsError{
+			{Line: 2, Code: "duplicate_item", Message: "item already exists: id 1"},
+			{Line: 3, Code: "name_required", Message: "name is required"},
+			{Line: 4, Code: "invalid_price", Message: "price must be an int of 0 or greater"},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected response (-want +got):\n%s", diff)
+	}
+}
+
+func TestImportItemsTooLarge(t *testing.T) {
+	t.Parallel()
+
+	csvBody := "name,category,price\n" + strings.Repeat("jacket,fashion,3000\n", 10)
+
+	multipartBody := &bytes.Buffer{}
+	mw := multipart.NewWriter(multipartBody)
+	fw, err := mw.CreateFormFile("file", "items.csv")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	if _, err := fw.Write([]byte(csvBody)); err != nil {
+		t.Fatalf("failed to write csv: %v", err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("failed to close multipart writer: %v", err)
+	}
+
+	cases := map[string]struct {
+		body        string
+		contentType string
+	}{
+		"ng: text/csv body": {
+			body:        csvBody,
+			contentType: "text/csv",
+		},
+		"ng: multipart file": {
+			body:        multipartBody.String(),
+			contentType: mw.FormDataContentType(),
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			h := &Handlers{maxUploadSize: 64, itemRepo: mockIR}
+
+			req := httptest.NewRequest("POST", "/items/import", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rr := httptest.NewRecorder()
+			h.ImportItems(rr, req)
+
+			if rr.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("expected status code %d, got %d: %s", http.StatusRequestEntityTooLarge, rr.Code, rr.Body.String())
+			}
+			var got ErrorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got.Code != "request_too_large" {
+				t.Errorf("expected error code request_too_large, got %s", got.Code)
+			}
+		})
+	}
+}
+
+func TestGetItem(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().UTC()
+	jacket := &Item{ID: 1, Name: "jacket", Category: "fashion", Price: 3000, CreatedAt: now.Add(-2 * time.Hour)}
+	iPhone := &Item{ID: 2, Name: "iPhone", Category: "phone", Price: 50000, CreatedAt: now.Add(-time.Hour)}
+	coat := &Item{ID: 3, Name: "coat", Category: "fashion", Price: 8000, CreatedAt: now}
+	onSale := &Item{ID: 4, Name: "scarf", Category: "fashion", Price: 1000, Tags: []string{"vintage", "sale"}, CreatedAt: now}
+
+	type wants struct {
+		code  int
+		items []*Item
+	}
+	cases := map[string]struct {
+		query    string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: newest first by default": {
+			query: "",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().List(gomock.Any()).Return([]*Item{jacket, iPhone, coat}, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				items: []*Item{coat, iPhone, jacket},
+			},
+		},
+		"ok: sorted by price": {
+			query: "?sort=price",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().List(gomock.Any()).Return([]*Item{jacket, iPhone, coat}, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				items: []*Item{jacket, coat, iPhone},
+			},
+		},
+		"ok: sorted by name descending": {
+			query: "?sort=name&order=desc",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().List(gomock.Any()).Return([]*Item{jacket, iPhone, coat}, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				items: []*Item{jacket, iPhone, coat},
+			},
+		},
+		"ok: filtered by tag": {
+			query: "?tag=sale",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().List(gomock.Any()).Return([]*Item{jacket, onSale}, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				items: []*Item{onSale},
+			},
+		},
+		"ok: filtered by price range and category": {
+			query: "?category=fashion&min_price=3000&max_price=8000",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListByCategory(gomock.Any(), "fashion").Return([]*Item{jacket, coat, onSale}, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				items: []*Item{coat, jacket},
+			},
+		},
+		"ok: filtered by min price only": {
+			query: "?min_price=8000",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().List(gomock.Any()).Return([]*Item{jacket, iPhone, coat}, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				items: []*Item{coat, iPhone},
+			},
+		},
+		"ok: filtered by max price only": {
+			query: "?max_price=3000",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().List(gomock.Any()).Return([]*Item{jacket, iPhone, onSale}, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				items: []*Item{onSale, jacket},
+			},
+		},
+		"ok: created since": {
+			query: "?since=" + url.QueryEscape(iPhone.CreatedAt.Format(time.RFC3339Nano)),
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().List(gomock.Any()).Return([]*Item{jacket, iPhone, coat}, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				items: []*Item{coat, iPhone},
+			},
+		},
+		"ng: since not in RFC 3339": {
+			query:    "?since=2025-04-01",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: negative min price": {
+			query:    "?min_price=-1",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: max price not an int": {
+			query:    "?max_price=cheap",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: min price greater than max price": {
+			query:    "?min_price=5000&max_price=1000",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: unknown sort key": {
+			query:    "?sort=id%3BDROP%20TABLE%20items",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: unknown order": {
+			query:    "?sort=name&order=up",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ok: filtered by category": {
+			query: "?category=fashion",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListByCategory(gomock.Any(), "fashion").Return([]*Item{jacket, coat}, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				items: []*Item{coat, jacket},
+			},
+		},
+		"ng: failed to list": {
+			query: "",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().List(gomock.Any()).Return(nil, errors.New("failed to list"))
+			},
+			wants: wants{
+				code: http.StatusInternalServerError,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", "/items"+tt.query, nil)
+			rr := httptest.NewRecorder()
+			h.GetItem(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.code >= 400 {
+				return
+			}
+
+			var got GetItemsResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			if diff := cmp.Diff(tt.wants.items, got.Items, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected items (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetItemETag(t *testing.T) {
+	t.Parallel()
+
+	updatedAt := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	items := []*Item{
+		{ID: 1, Name: "jacket", Category: "fashion", UpdatedAt: updatedAt},
+		{ID: 2, Name: "coat", Category: "fashion", UpdatedAt: updatedAt.Add(time.Hour)},
+	}
+	etag := itemsETag(items)
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		ifNoneMatch string
+		items       []*Item
+		wants
+	}{
+		"ok: no If-None-Match": {
+			items: items,
+			wants: wants{code: http.StatusOK},
+		},
+		"ok: not modified": {
+			ifNoneMatch: etag,
+			items:       items,
+			wants:       wants{code: http.StatusNotModified},
+		},
+		"ok: one of the ETags matches": {
+			ifNoneMatch: `"other", ` + etag,
+			items:       items,
+			wants:       wants{code: http.StatusNotModified},
+		},
+		"ok: an item was updated": {
+			ifNoneMatch: etag,
+			items: []*Item{
+				items[0],
+				{ID: 2, Name: "coat", Category: "fashion", UpdatedAt: updatedAt.Add(2 * time.Hour)},
+			},
+			wants: wants{code: http.StatusOK},
+		},
+		"ok: an item was deleted": {
+			ifNoneMatch: etag,
+			items:       items[1:],
+			wants:       wants{code: http.StatusOK},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			mockIR.EXPECT().List(gomock.Any()).Return(tt.items, nil)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", "/items", nil)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			rr := httptest.NewRecorder()
+			h.GetItem(rr, req)
+
+			if rr.Code != tt.wants.code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if got := rr.Header().Get("ETag"); got != itemsETag(tt.items) {
+				t.Errorf("expected ETag %s, got %s", itemsETag(tt.items), got)
+			}
+			if tt.wants.code == http.StatusNotModified && rr.Body.Len() != 0 {
+				t.Errorf("expected no body, got %s", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestPrefersXML(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		accept string
+		want   bool
+	}{
+		"ok: no Accept":              {accept: "", want: false},
+		"ok: json":                   {accept: "application/json", want: false},
+		"ok: xml":                    {accept: "application/xml", want: true},
+		"ok: text/xml":               {accept: "text/xml", want: true},
+		"ok: xml over any":           {accept: "application/xml, */*;q=0.1", want: true},
+		"ok: json preferred by q":    {accept: "application/xml;q=0.5, application/json", want: false},
+		"ok: xml preferred by q":     {accept: "application/xml, application/json;q=0.5", want: true},
+		"ok: tie is json":            {accept: "application/xml, application/json", want: false},
+		"ok: json refused":           {accept: "*/*, application/json;q=0, application/xml;q=0.1", want: true},
+		"ok: xml refused":            {accept: "application/xml;q=0", want: false},
+		"ok: unsupported media type": {accept: "text/html", want: false},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := prefersXML(tt.accept); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetItemXML(t *testing.T) {
+	t.Parallel()
+
+	items := []*Item{
+		{ID: 1, Name: "jacket", Category: "fashion", ImageName: "abc.jpg", Tags: []string{"winter"}},
+		{ID: 2, Name: "coat", Category: "fashion"},
+	}
+
+	ctrl := gomock.NewController(t)
+
+	mockIR := NewMockItemRepository(ctrl)
+	mockIR.EXPECT().List(gomock.Any()).Return(items, nil)
+	mockIR.EXPECT().Select(gomock.Any(), 1).Return(items[0], nil)
+	h := &Handlers{itemRepo: mockIR}
+
+	type xmlItem struct {
+		ID       int      `xml:"id"`
+		Name     string   `xml:"name"`
+		Tags     []string `xml:"tags>tag"`
+		ImageURL string   `xml:"image_url"`
+	}
+	want := xmlItem{ID: 1, Name: "jacket", Tags: []string{"winter"}, ImageURL: "/images/abc.jpg"}
+
+	// the list
+	req := httptest.NewRequest("GET", "/items", nil)
+	req.Header.Set("Accept", "application/xml")
+	rr := httptest.NewRecorder()
+	h.GetItem(rr, req)
+
+	if got := rr.Header().Get("Content-Type"); got != "application/xml; charset=utf-8" {
+		t.Errorf("expected Content-Type application/xml; charset=utf-8, got %s", got)
+	}
+	var list struct {
+		XMLName xml.Name  `xml:"items"`
+		Items   []xmlItem `xml:"item"`
+	}
+	if err := xml.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	idx := slices.IndexFunc(list.Items, func(item xmlItem) bool { return item.ID == 1 })
+	if len(list.Items) != 2 || idx < 0 {
+		t.Fatalf("expected items 1 and 2, got %v", list.Items)
+	}
+	if diff := cmp.Diff(want, list.Items[idx]); diff != "" {
+		t.Errorf("unexpected item (-want +got):\n%s", diff)
+	}
+
+	// a single item
+	req = httptest.NewRequest("GET", "/items/1", nil)
+	req.SetPathValue("id", "1")
+	req.Header.Set("Accept", "text/xml")
+	rr = httptest.NewRecorder()
+	h.GetAnItem(rr, req)
+
+	var got xmlItem
+	if err := xml.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected item (-want +got):\n%s", diff)
+	}
+}
+
+func TestPrettyJSON(t *testing.T) {
+	t.Parallel()
+
+	item := &Item{ID: 1, Name: "jacket", Category: "fashion"}
+
+	type wants struct {
+		code     int
+		indented bool
+	}
+	cases := map[string]struct {
+		path     string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: compact by default": {
+			path: "/items",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().List(gomock.Any()).Return([]*Item{item}, nil)
+			},
+			wants: wants{code: http.StatusOK},
+		},
+		"ok: pretty list": {
+			path: "/items?pretty=true",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().List(gomock.Any()).Return([]*Item{item}, nil)
+			},
+			wants: wants{code: http.StatusOK, indented: true},
+		},
+		"ok: pretty item": {
+			path: "/items/1?pretty=1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Select(gomock.Any(), 1).Return(item, nil)
+			},
+			wants: wants{code: http.StatusOK, indented: true},
+		},
+		"ok: pretty=false": {
+			path: "/items/1?pretty=false",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Select(gomock.Any(), 1).Return(item, nil)
+			},
+			wants: wants{code: http.StatusOK},
+		},
+		"ng: invalid pretty": {
+			path:     "/items?pretty=yes",
+			injector: func(m *MockItemRepository) {},
+			wants:    wants{code: http.StatusBadRequest},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			rr := httptest.NewRecorder()
+			if strings.HasPrefix(tt.path, "/items/") {
+				req.SetPathValue("id", "1")
+				h.GetAnItem(rr, req)
+			} else {
+				h.GetItem(rr, req)
+			}
+
+			if rr.Code != tt.wants.code {
+				t.Fatalf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.code != http.StatusOK {
+				return
+			}
+			if got := strings.Contains(rr.Body.String(), "\n  \""); got != tt.wants.indented {
+				t.Errorf("expected indented %v, got %s", tt.wants.indented, rr.Body.String())
+			}
+			if !json.Valid(rr.Body.Bytes()) {
+				t.Errorf("expected valid JSON, got %s", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestItemFields(t *testing.T) {
+	t.Parallel()
+
+	jacket := &Item{ID: 1, Name: "jacket", Category: "fashion", ImageName: "a.jpg", Price: 3000}
+
+	type wants struct {
+		code int
+		body string
+	}
+	cases := map[string]struct {
+		path     string
+		id       string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: list with fields": {
+			path: "/items?fields=name,image_url",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().List(gomock.Any()).Return([]*Item{jacket}, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+				body: `{"items":[{"name":"jacket","image_url":"/images/a.jpg"}]}`,
+			},
+		},
+		"ok: item with fields": {
+			path: "/items/1?fields=name,%20price,name",
+			id:   "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Select(gomock.Any(), 1).Return(jacket, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+				body: `{"name":"jacket","price":3000}`,
+			},
+		},
+		"ng: unknown field in list": {
+			path:     "/items?fields=name,password",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: unknown field in item": {
+			path:     "/items/1?fields=Name",
+			id:       "1",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			rr := httptest.NewRecorder()
+			if tt.id != "" {
+				req.SetPathValue("id", tt.id)
+				h.GetAnItem(rr, req)
+			} else {
+				h.GetItem(rr, req)
+			}
+
+			if tt.wants.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.code != http.StatusOK {
+				var errResp ErrorResponse
+				if err := json.NewDecoder(rr.Body).Decode(&errResp); err != nil {
+					t.Fatalf("failed to decode error response: %v", err)
+				}
+				if errResp.Code != "invalid_field" {
+					t.Errorf("expected error code invalid_field, got %s", errResp.Code)
+				}
+				return
+			}
+
+			var want, got any
+			if err := json.Unmarshal([]byte(tt.wants.body), &want); err != nil {
+				t.Fatalf("failed to decode expected body: %v", err)
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("unexpected body (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExportItemsCSV(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+
+	mockIR := NewMockItemRepository(ctrl)
+	mockIR.EXPECT().List(gomock.Any()).Return([]*Item{
+		{ID: 1, Name: "jacket", Category: "fashion", Price: 3000, ImageName: "a.jpg"},
+		{ID: 2, Name: "iPhone 16e, used", Category: "phone", Price: 50000, ImageName: "b.jpg"},
+	}, nil)
+	h := &Handlers{itemRepo: mockIR}
+
+	req := httptest.NewRequest("GET", "/items.csv", nil)
+	rr := httptest.NewRecorder()
+	h.ExportItemsCSV(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	if got := rr.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/csv") {
+		t.Errorf("expected Content-Type text/csv, got %s", got)
+	}
+	if got := rr.Header().Get("Content-Disposition"); !strings.HasPrefix(got, "attachment") {
+		t.Errorf("expected an attachment, got %s", got)
+	}
+
+	want := "id,name,category,price,image\n" +
+		"1,jacket,fashion,3000,a.jpg\n" +
+		"2,\"iPhone 16e, used\",phone,50000,b.jpg\n"
+	if diff := cmp.Diff(want, rr.Body.String()); diff != "" {
+		t.Errorf("unexpected csv (-want +got):\n%s", diff)
+	}
+}
+
+func TestExportItemsNDJSON(t *testing.T) {
+	t.Parallel()
+
+	items := []*Item{
+		{ID: 1, Name: "jacket", Category: "fashion", Price: 3000, ImageName: "a.jpg"},
+		{ID: 2, Name: "iPhone", Category: "phone", Price: 50000, ImageName: "b.jpg"},
+	}
+
+	ctrl := gomock.NewController(t)
+
+	mockIR := NewMockItemRepository(ctrl)
+	mockIR.EXPECT().List(gomock.Any()).Return(items, nil)
+	h := &Handlers{itemRepo: mockIR}
+
+	req := httptest.NewRequest("GET", "/items.ndjson", nil)
+	rr := httptest.NewRecorder()
+	h.ExportItemsNDJSON(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	if got := rr.Header().Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("expected Content-Type application/x-ndjson, got %s", got)
+	}
+	if !rr.Flushed {
+		t.Errorf("expected the items to be flushed")
+	}
+
+	// one item per line
+	lines := strings.Split(strings.TrimSuffix(rr.Body.String(), "\n"), "\n")
+	if len(lines) != len(items) {
+		t.Fatalf("expected %d lines, got %d: %q", len(items), len(lines), rr.Body.String())
+	}
+	for idx, line := range lines {
+		var got Item
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("failed to decode line %d: %v", idx, err)
+		}
+		if diff := cmp.Diff(items[idx], &got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("unexpected item on line %d (-want +got):\n%s", idx, diff)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code int
+		resp StatsResponse
+	}
+	cases := map[string]struct {
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: counted": {
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Count(gomock.Any()).Return(3, nil)
+				m.EXPECT().ListCategories(gomock.Any()).Return([]*Category{{ID: 1, Name: "fashion"}, {ID: 2, Name: "phone"}}, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+				resp: StatsResponse{Items: 3, Categories: 2},
+			},
+		},
+		"ng: failed to count": {
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Count(gomock.Any()).Return(0, errors.New("failed to count"))
+			},
+			wants: wants{
+				code: http.StatusInternalServerError,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", "/stats", nil)
+			rr := httptest.NewRecorder()
+			h.Stats(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if got := rr.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("expected Content-Type application/json, got %s", got)
+			}
+			if tt.wants.code != http.StatusOK {
+				return
+			}
+
+			var got StatsResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if diff := cmp.Diff(tt.wants.resp, got); diff != "" {
+				t.Errorf("unexpected stats (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetCategoryItems(t *testing.T) {
+	t.Parallel()
+
+	categories := []*Category{{ID: 1, Name: "fashion"}, {ID: 2, Name: "phone"}}
+	jacket := &Item{ID: 1, Name: "jacket", Category: "fashion"}
+
+	type wants struct {
+		code  int
+		items []*Item
+	}
+	cases := map[string]struct {
+		name     string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: items in the category": {
+			name: "fashion",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListCategories(gomock.Any()).Return(categories, nil)
+				m.EXPECT().ListByCategory(gomock.Any(), "fashion").Return([]*Item{jacket}, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				items: []*Item{jacket},
+			},
+		},
+		"ok: empty category": {
+			name: "phone",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListCategories(gomock.Any()).Return(categories, nil)
+				m.EXPECT().ListByCategory(gomock.Any(), "phone").Return([]*Item{}, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				items: []*Item{},
+			},
+		},
+		"ng: unknown category": {
+			name: "food",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListCategories(gomock.Any()).Return(categories, nil)
+			},
+			wants: wants{
+				code: http.StatusNotFound,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", "/categories/"+tt.name+"/items", nil)
+			req.SetPathValue("name", tt.name)
+			rr := httptest.NewRecorder()
+			h.GetCategoryItems(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.code != http.StatusOK {
+				return
+			}
+
+			var got GetItemsResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if diff := cmp.Diff(tt.wants.items, got.Items, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected items (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetCategoryNames(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code       int
+		categories []string
+	}
+	cases := map[string]struct {
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: sorted by name": {
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListCategories(gomock.Any()).Return([]*Category{{ID: 1, Name: "phone"}, {ID: 2, Name: "fashion"}, {ID: 3, Name: "books"}}, nil)
+			},
+			wants: wants{
+				code:       http.StatusOK,
+				categories: []string{"books", "fashion", "phone"},
+			},
+		},
+		"ok: no categories": {
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListCategories(gomock.Any()).Return([]*Category{}, nil)
+			},
+			wants: wants{
+				code:       http.StatusOK,
+				categories: []string{},
+			},
+		},
+		"ng: failed to list": {
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListCategories(gomock.Any()).Return(nil, errors.New("failed to list"))
+			},
+			wants: wants{
+				code: http.StatusInternalServerError,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", "/items/categories", nil)
+			rr := httptest.NewRecorder()
+			h.GetCategoryNames(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.code != http.StatusOK {
+				return
+			}
+
+			var got GetCategoryNamesResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if diff := cmp.Diff(tt.wants.categories, got.Categories); diff != "" {
+				t.Errorf("unexpected categories (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDeleteCategory(t *testing.T) {
+	t.Parallel()
+
+	categories := []*Category{{ID: 1, Name: "fashion"}, {ID: 2, Name: "clothes"}}
+
+	type wants struct {
+		code    int
+		errCode string
+	}
+	cases := map[string]struct {
+		name       string
+		reassignTo string
+		injector   func(m *MockItemRepository)
+		wants
+	}{
+		"ok: items reassigned": {
+			name:       "fashion",
+			reassignTo: "clothes",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListCategories(gomock.Any()).Return(categories, nil)
+				m.EXPECT().ReassignCategory(gomock.Any(), "fashion", "clothes").Return(2, nil)
+			},
+			wants: wants{
+				code: http.StatusNoContent,
+			},
+		},
+		"ng: no reassignment target": {
+			name: "fashion",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListCategories(gomock.Any()).Return(categories, nil)
+			},
+			wants: wants{
+				code:    http.StatusBadRequest,
+				errCode: "reassign_to_required",
+			},
+		},
+		"ng: reassigned to itself": {
+			name:       "fashion",
+			reassignTo: "fashion",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListCategories(gomock.Any()).Return(categories, nil)
+			},
+			wants: wants{
+				code:    http.StatusBadRequest,
+				errCode: "invalid_reassign_to",
+			},
+		},
+		"ng: unknown reassignment target": {
+			name:       "fashion",
+			reassignTo: "food",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListCategories(gomock.Any()).Return(categories, nil)
+			},
+			wants: wants{
+				code:    http.StatusBadRequest,
+				errCode: "invalid_reassign_to",
+			},
+		},
+		"ng: unknown category": {
+			name:       "food",
+			reassignTo: "clothes",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListCategories(gomock.Any()).Return(categories, nil)
+			},
+			wants: wants{
+				code:    http.StatusNotFound,
+				errCode: "category_not_found",
+			},
+		},
+		"ng: duplicate item": {
+			name:       "fashion",
+			reassignTo: "clothes",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListCategories(gomock.Any()).Return(categories, nil)
+				m.EXPECT().ReassignCategory(gomock.Any(), "fashion", "clothes").Return(0, errDuplicateItem)
+			},
+			wants: wants{
+				code:    http.StatusConflict,
+				errCode: "duplicate_item",
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("DELETE", "/categories/"+tt.name+"?reassign_to="+url.QueryEscape(tt.reassignTo), nil)
+			req.SetPathValue("name", tt.name)
+			rr := httptest.NewRecorder()
+			h.DeleteCategory(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.errCode == "" {
+				return
+			}
+			var got ErrorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode error response: %v", err)
+			}
+			if got.Code != tt.wants.errCode {
+				t.Errorf("expected error code %s, got %s", tt.wants.errCode, got.Code)
+			}
+		})
+	}
+}
+
+func TestMergeCategories(t *testing.T) {
+	t.Parallel()
+
+	categories := []*Category{{ID: 1, Name: "Shoes"}, {ID: 2, Name: "shoe"}}
+
+	type wants struct {
+		code    int
+		errCode string
+		moved   int
+	}
+	cases := map[string]struct {
+		body     string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: merged": {
+			body: `{"from":"shoe","into":"Shoes"}`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListCategories(gomock.Any()).Return(categories, nil)
+				m.EXPECT().ReassignCategory(gomock.Any(), "shoe", "Shoes").Return(3, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				moved: 3,
+			},
+		},
+		"ng: from missing": {
+			body:     `{"into":"Shoes"}`,
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code:    http.StatusBadRequest,
+				errCode: "from_required",
+			},
+		},
+		"ng: into missing": {
+			body:     `{"from":"shoe"}`,
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code:    http.StatusBadRequest,
+				errCode: "into_required",
+			},
+		},
+		"ng: same category": {
+			body:     `{"from":"shoe","into":"shoe"}`,
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code:    http.StatusBadRequest,
+				errCode: "invalid_into",
+			},
+		},
+		"ng: invalid json": {
+			body:     `{"from":`,
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code:    http.StatusBadRequest,
+				errCode: "invalid_json",
+			},
+		},
+		"ng: unknown category": {
+			body: `{"from":"shoe","into":"boots"}`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListCategories(gomock.Any()).Return(categories, nil)
+			},
+			wants: wants{
+				code:    http.StatusNotFound,
+				errCode: "category_not_found",
+			},
+		},
+		"ng: duplicate item": {
+			body: `{"from":"shoe","into":"Shoes"}`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListCategories(gomock.Any()).Return(categories, nil)
+				m.EXPECT().ReassignCategory(gomock.Any(), "shoe", "Shoes").Return(0, errDuplicateItem)
+			},
+			wants: wants{
+				code:    http.StatusConflict,
+				errCode: "duplicate_item",
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("POST", "/categories/merge", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			h.MergeCategories(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.errCode != "" {
+				var got ErrorResponse
+				if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+					t.Fatalf("failed to decode error response: %v", err)
+				}
+				if got.Code != tt.wants.errCode {
+					t.Errorf("expected error code %s, got %s", tt.wants.errCode, got.Code)
+				}
+				return
+			}
+
+			var got MergeCategoriesResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got.Moved != tt.wants.moved {
+				t.Errorf("expected %d items moved, got %d", tt.wants.moved, got.Moved)
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code  int
+		total int
+	}
+	cases := map[string]struct {
+		query    string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: items found": {
+			query: "keyword=jacket",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().SearchPage(gomock.Any(), "jacket", searchContains, 0, 0).Return([]*Item{{Name: "jacket", Category: "fashion"}}, 1, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				total: 1,
+			},
+		},
+		"ok: paged": {
+			query: "keyword=jacket&limit=1&offset=1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().SearchPage(gomock.Any(), "jacket", searchContains, 1, 1).Return([]*Item{{Name: "jacket", Category: "fashion"}}, 3, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				total: 3,
+			},
+		},
+		"ok: prefix mode": {
+			query: "keyword=jack&mode=prefix",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().SearchPage(gomock.Any(), "jack", searchPrefix, 0, 0).Return([]*Item{{Name: "jacket", Category: "fashion"}}, 1, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				total: 1,
+			},
+		},
+		"ng: invalid mode": {
+			query:    "keyword=jacket&mode=exact",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: empty keyword": {
+			query:    "keyword=",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: invalid limit": {
+			query:    "keyword=jacket&limit=0",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: invalid offset": {
+			query:    "keyword=jacket&offset=-1",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: failed to search": {
+			query: "keyword=jacket",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().SearchPage(gomock.Any(), "jacket", searchContains, 0, 0).Return(nil, 0, errors.New("failed to search"))
+			},
+			wants: wants{
+				code: http.StatusInternalServerError,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", "/search?"+tt.query, nil)
+			rr := httptest.NewRecorder()
+			h.Search(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.code >= 400 {
+				return
+			}
+
+			var got SearchResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got.Total != tt.wants.total {
+				t.Errorf("expected total %d, got %d", tt.wants.total, got.Total)
+			}
+			if got := rr.Header().Get("X-Total-Count"); got != strconv.Itoa(tt.wants.total) {
+				t.Errorf("expected X-Total-Count %d, got %q", tt.wants.total, got)
+			}
+			if len(got.Items) != 1 || got.Items[0].Name != "jacket" {
+				t.Errorf("expected the jacket, got %v", got.Items)
+			}
+		})
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code        int
+		suggestions []string
+	}
+	cases := map[string]struct {
+		query    string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: suggestions": {
+			query: "?q=jac",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Suggest(gomock.Any(), "jac", maxSuggestions).Return([]string{"jacket"}, nil)
+			},
+			wants: wants{
+				code:        http.StatusOK,
+				suggestions: []string{"jacket"},
+			},
+		},
+		"ok: empty q": {
+			query:    "",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code:        http.StatusOK,
+				suggestions: []string{},
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", "/suggest"+tt.query, nil)
+			rr := httptest.NewRecorder()
+			h.Suggest(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+
+			var got SuggestResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if diff := cmp.Diff(tt.wants.suggestions, got.Suggestions); diff != "" {
+				t.Errorf("unexpected suggestions (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCountItems(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code  int
+		count int
+	}
+	cases := map[string]struct {
+		query    string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: all items": {
+			query: "",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Count(gomock.Any()).Return(123, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				count: 123,
+			},
+		},
+		"ok: items matching keyword": {
+			query: "?keyword=jacket",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Search(gomock.Any(), "jacket", searchContains).Return([]*Item{{Name: "jacket"}, {Name: "red jacket"}}, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				count: 2,
+			},
+		},
+		"ng: failed to count": {
+			query: "",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Count(gomock.Any()).Return(0, errors.New("failed to count"))
+			},
+			wants: wants{
+				code: http.StatusInternalServerError,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", "/items/count"+tt.query, nil)
+			rr := httptest.NewRecorder()
+			h.CountItems(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.code >= 400 {
+				return
+			}
+
+			var got CountItemsResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			if got.Count != tt.wants.count {
+				t.Errorf("expected count %d, got %d", tt.wants.count, got.Count)
+			}
+		})
+	}
+}
+
+func TestUpdateItem(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code       int
+		errCode    string
+		existingID int
+	}
+	cases := map[string]struct {
+		id       string
+		args     map[string]string
+		ifMatch  string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: correctly updated": {
+			id: "1",
+			args: map[string]string{
+				"name":     "used iPhone 16e",
+				"category": "phone",
+				"version":  "1",
+			},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Update(gomock.Any(), &Item{ID: 1, Name: "used iPhone 16e", Category: "phone", Version: 1}).Return(nil)
+				m.EXPECT().Select(gomock.Any(), 1).Return(&Item{ID: 1, Name: "used iPhone 16e", Category: "phone", Version: 2}, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ok: version from If-Match": {
+			id: "1",
+			args: map[string]string{
+				"name":     "used iPhone 16e",
+				"category": "phone",
+			},
+			ifMatch: `"3"`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Update(gomock.Any(), &Item{ID: 1, Name: "used iPhone 16e", Category: "phone", Version: 3}).Return(nil)
+				m.EXPECT().Select(gomock.Any(), 1).Return(&Item{ID: 1, Name: "used iPhone 16e", Category: "phone", Version: 4}, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: version conflict": {
+			id: "1",
+			args: map[string]string{
+				"name":     "used iPhone 16e",
+				"category": "phone",
+				"version":  "1",
+			},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Update(gomock.Any(), gomock.Any()).Return(errVersionConflict)
+			},
+			wants: wants{
+				code:    http.StatusConflict,
+				errCode: "version_conflict",
+			},
+		},
+		"ng: duplicate item": {
+			id: "1",
+			args: map[string]string{
+				"name":     "used iPhone 16e",
+				"category": "phone",
+				"version":  "1",
+			},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Update(gomock.Any(), gomock.Any()).Return(&duplicateItemError{ID: 5})
+			},
+			wants: wants{
+				code:       http.StatusConflict,
+				errCode:    "duplicate_item",
+				existingID: 5,
+			},
+		},
+		"ng: version required": {
+			id: "1",
+			args: map[string]string{
+				"name":     "used iPhone 16e",
+				"category": "phone",
+			},
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code:    http.StatusBadRequest,
+				errCode: "version_required",
+			},
+		},
+		"ok: any version with If-Match *": {
+			id: "1",
+			args: map[string]string{
+				"name":     "used iPhone 16e",
+				"category": "phone",
+			},
+			ifMatch: "*",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Update(gomock.Any(), &Item{ID: 1, Name: "used iPhone 16e", Category: "phone"}).Return(nil)
+				m.EXPECT().Select(gomock.Any(), 1).Return(&Item{ID: 1, Name: "used iPhone 16e", Category: "phone", Version: 4}, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: item not found with If-Match *": {
+			id: "2",
+			args: map[string]string{
+				"name":     "used iPhone 16e",
+				"category": "phone",
+			},
+			ifMatch: "*",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Update(gomock.Any(), &Item{ID: 2, Name: "used iPhone 16e", Category: "phone"}).Return(errItemNotFound)
+			},
+			wants: wants{
+				code:    http.StatusNotFound,
+				errCode: "item_not_found",
+			},
+		},
+		"ng: invalid version": {
+			id: "1",
+			args: map[string]string{
+				"name":     "used iPhone 16e",
+				"category": "phone",
+			},
+			ifMatch:  `"abc"`,
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code:    http.StatusBadRequest,
+				errCode: "invalid_version",
+			},
+		},
+		"ng: item not found": {
+			id: "2",
+			args: map[string]string{
+				"name":     "used iPhone 16e",
+				"category": "phone",
+				"version":  "1",
+			},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Update(gomock.Any(), gomock.Any()).Return(errItemNotFound)
+			},
+			wants: wants{
+				code: http.StatusNotFound,
+			},
+		},
+		"ng: empty name": {
+			id: "1",
+			args: map[string]string{
+				"category": "phone",
+				"version":  "1",
+			},
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := newMultipartRequest(t, "PUT", "/items/"+tt.id, tt.args, nil)
+			req.SetPathValue("id", tt.id)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			rr := httptest.NewRecorder()
+			h.UpdateItem(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.code >= 400 {
+				if tt.wants.errCode == "" {
+					return
+				}
+				var errResp UpdateDuplicateItemResponse
+				if err := json.NewDecoder(rr.Body).Decode(&errResp); err != nil {
+					t.Fatalf("failed to decode error response: %v", err)
+				}
+				if errResp.Code != tt.wants.errCode {
+					t.Errorf("expected error code %s, got %s", tt.wants.errCode, errResp.Code)
+				}
+				if errResp.ExistingID != tt.wants.existingID {
+					t.Errorf("expected existing id %d, got %d", tt.wants.existingID, errResp.ExistingID)
+				}
+				return
+			}
+
+			for _, v := range tt.args {
+				if !strings.Contains(rr.Body.String(), v) {
+					t.Errorf("response body does not contain %s, got: %s", v, rr.Body.String())
+				}
+			}
+			// the new version is returned as the ETag for the next update
+			var got Item
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if want := fmt.Sprintf(`"%d"`, got.Version); rr.Header().Get("ETag") != want {
+				t.Errorf("expected ETag %s, got %s", want, rr.Header().Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestPurchaseItem(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code  int
+		stock int
+	}
+	cases := map[string]struct {
+		id       string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: purchased": {
+			id: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Purchase(gomock.Any(), 1).Return(&Item{ID: 1, Name: "jacket", Stock: 2}, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				stock: 2,
+			},
+		},
+		"ng: out of stock": {
+			id: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Purchase(gomock.Any(), 1).Return(nil, errOutOfStock)
+			},
+			wants: wants{
+				code: http.StatusConflict,
+			},
+		},
+		"ng: item not found": {
+			id: "2",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Purchase(gomock.Any(), 2).Return(nil, errItemNotFound)
+			},
+			wants: wants{
+				code: http.StatusNotFound,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("POST", "/items/"+tt.id+"/purchase", nil)
+			req.SetPathValue("id", tt.id)
+			rr := httptest.NewRecorder()
+			h.PurchaseItem(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.code != http.StatusOK {
+				return
+			}
+
+			var got Item
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got.Stock != tt.wants.stock {
+				t.Errorf("expected stock %d, got %d", tt.wants.stock, got.Stock)
+			}
+		})
+	}
+}
+
+func TestRandomItem(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: item returned": {
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().SelectRandom(gomock.Any()).Return(&Item{ID: 1, Name: "jacket", Category: "fashion"}, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: no items": {
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().SelectRandom(gomock.Any()).Return(nil, errItemNotFound)
+			},
+			wants: wants{
+				code: http.StatusNotFound,
+			},
+		},
+		"ng: failed to select": {
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().SelectRandom(gomock.Any()).Return(nil, errors.New("failed to select"))
+			},
+			wants: wants{
+				code: http.StatusInternalServerError,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", "/items/random", nil)
+			rr := httptest.NewRecorder()
+			h.RandomItem(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+		})
+	}
+}
+
+func TestGetSimilarItems(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code  int
+		items int
+	}
+	cases := map[string]struct {
+		id       string
+		query    string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: default limit": {
+			id: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListSimilar(gomock.Any(), 1, defaultSimilarLimit).Return([]*Item{{ID: 2, Name: "coat", Category: "fashion"}}, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				items: 1,
+			},
+		},
+		"ok: no similar items": {
+			id:    "1",
+			query: "?limit=5",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListSimilar(gomock.Any(), 1, 5).Return([]*Item{}, nil)
+			},
+			wants: wants{
+				code:  http.StatusOK,
+				items: 0,
+			},
+		},
+		"ng: invalid limit": {
+			id:       "1",
+			query:    "?limit=0",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: item not found": {
+			id: "2",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListSimilar(gomock.Any(), 2, defaultSimilarLimit).Return(nil, errItemNotFound)
+			},
+			wants: wants{
+				code: http.StatusNotFound,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", "/items/"+tt.id+"/similar"+tt.query, nil)
+			req.SetPathValue("id", tt.id)
+			rr := httptest.NewRecorder()
+			h.GetSimilarItems(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.code != http.StatusOK {
+				return
+			}
+
+			// an empty result is an empty list, not null
+			var got struct {
+				Items []*Item `json:"items"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got.Items == nil || len(got.Items) != tt.wants.items {
+				t.Errorf("expected %d items, got %v", tt.wants.items, got.Items)
+			}
+		})
+	}
+}
+
+func TestGetItemImage(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code     int
+		location string
+	}
+	cases := map[string]struct {
+		id       string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: redirected to the item image": {
+			id: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Select(gomock.Any(), 1).Return(&Item{ID: 1, Name: "jacket", ImageName: "a1b2.jpg"}, nil)
+			},
+			wants: wants{
+				code:     http.StatusFound,
+				location: "/images/a1b2.jpg",
+			},
+		},
+		"ok: item without image": {
+			id: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Select(gomock.Any(), 1).Return(&Item{ID: 1, Name: "jacket"}, nil)
+			},
+			wants: wants{
+				code:     http.StatusFound,
+				location: "/images/placeholder.png",
+			},
+		},
+		"ok: item not found": {
+			id: "2",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Select(gomock.Any(), 2).Return(nil, errItemNotFound)
+			},
+			wants: wants{
+				code:     http.StatusFound,
+				location: "/images/placeholder.png",
+			},
+		},
+		"ng: failed to select": {
+			id: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Select(gomock.Any(), 1).Return(nil, errors.New("failed to select"))
+			},
+			wants: wants{
+				code: http.StatusInternalServerError,
+			},
+		},
+		"ng: invalid id": {
+			id:       "abc",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{defaultImage: "placeholder.png", itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", "/items/"+tt.id+"/image", nil)
+			req.SetPathValue("id", tt.id)
+			rr := httptest.NewRecorder()
+			h.GetItemImage(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if got := rr.Header().Get("Location"); got != tt.wants.location {
+				t.Errorf("expected Location %q, got %q", tt.wants.location, got)
+			}
+		})
+	}
+}
+
+func TestGetAnItemCategory(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := &itemRepository{cache: newItemCache(10)}
+	h := &Handlers{itemRepo: repo}
+
+	ids := map[string]int{}
+	for _, item := range []*Item{
+		{Name: "jacket", Category: "fashion"},
+		{Name: "iPhone", Category: "phone"},
+	} {
+		id, err := repo.Insert(ctx, item)
+		if err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+		ids[item.Category] = id
+	}
+
+	// the category is returned by name, both from the store and from the cache on the second request
+	for category, id := range ids {
+		for range 2 {
+			req := httptest.NewRequest("GET", "/items/"+strconv.Itoa(id), nil)
+			req.SetPathValue("id", strconv.Itoa(id))
+			rr := httptest.NewRecorder()
+			h.GetAnItem(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+			}
+			var got Item
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got.Category != category {
+				t.Errorf("expected category %q, got %q", category, got.Category)
+			}
+		}
+	}
+}
+
+func TestHeadItem(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		id       string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: item exists": {
+			id: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Select(gomock.Any(), 1).Return(&Item{ID: 1, Name: "jacket", Category: "fashion"}, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: item not found": {
+			id: "2",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Select(gomock.Any(), 2).Return(nil, errItemNotFound)
+			},
+			wants: wants{
+				code: http.StatusNotFound,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			//GET と HEAD で 1 回ずつ呼ばれる
+			tt.injector(mockIR)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			//HEAD のボディを捨てるのは net/http のサーバーなので、ResponseRecorder ではなく実際のサーバーを使う
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /items/{id}", h.GetAnItem)
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			getResp, err := http.Get(srv.URL + "/items/" + tt.id)
+			if err != nil {
+				t.Fatalf("failed to GET item: %v", err)
+			}
+			getResp.Body.Close()
+			headResp, err := http.Head(srv.URL + "/items/" + tt.id)
+			if err != nil {
+				t.Fatalf("failed to HEAD item: %v", err)
+			}
+			body, err := io.ReadAll(headResp.Body)
+			headResp.Body.Close()
+			if err != nil {
+				t.Fatalf("failed to read HEAD body: %v", err)
+			}
+
+			if tt.wants.code != headResp.StatusCode {
+				t.Fatalf("expected status code %d, got %d", tt.wants.code, headResp.StatusCode)
+			}
+			if len(body) != 0 {
+				t.Errorf("expected empty body, got %q", body)
+			}
+			for _, key := range []string{"Content-Type", "Content-Length", "ETag", "Vary"} {
+				if got, want := headResp.Header.Get(key), getResp.Header.Get(key); got != want {
+					t.Errorf("expected %s %q as for GET, got %q", key, want, got)
+				}
+			}
+			if tt.wants.code == http.StatusOK && headResp.ContentLength <= 0 {
+				t.Errorf("expected Content-Length of the item, got %d", headResp.ContentLength)
+			}
+		})
+	}
+}
+
+func TestAdminGetItems(t *testing.T) {
+	t.Parallel()
+
+	deletedAt := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	items := []*Item{
+		{ID: 1, Name: "jacket", Category: "fashion", DeletedAt: &deletedAt},
+		{ID: 2, Name: "coat", Category: "fashion"},
+	}
+
+	ctrl := gomock.NewController(t)
+
+	mockIR := NewMockItemRepository(ctrl)
+	mockIR.EXPECT().ListAll(gomock.Any()).Return(items, nil)
+	h := &Handlers{itemRepo: mockIR}
+
+	req := httptest.NewRequest("GET", "/admin/items", nil)
+	rr := httptest.NewRecorder()
+	h.AdminGetItems(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	var got GetItemsResponse
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if diff := cmp.Diff(items, got.Items, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("unexpected items (-want +got):\n%s", diff)
+	}
+}
+
+func TestMyItems(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		sellerID string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: items of the seller": {
+			sellerID: "seller1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ListBySeller(gomock.Any(), "seller1").Return([]*Item{{ID: 1, Name: "jacket", Category: "fashion", SellerID: "seller1"}}, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: not authenticated": {
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusUnauthorized,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", "/my/items", nil)
+			if tt.sellerID != "" {
+				req = req.WithContext(context.WithValue(req.Context(), sellerIDKey{}, tt.sellerID))
+			}
+			rr := httptest.NewRecorder()
+			h.MyItems(rr, req)
+
+			if rr.Code != tt.wants.code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.code == http.StatusOK && !strings.Contains(rr.Body.String(), `"seller_id":"seller1"`) {
+				t.Errorf("expected the seller id in the response, got %s", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestRestoreItem(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		id       string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: restored": {
+			id: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Restore(gomock.Any(), 1).Return(&Item{ID: 1, Name: "jacket", Category: "fashion"}, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: duplicate item": {
+			id: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Restore(gomock.Any(), 1).Return(nil, errDuplicateItem)
+			},
+			wants: wants{
+				code: http.StatusConflict,
+			},
+		},
+		"ng: item not found": {
+			id: "2",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Restore(gomock.Any(), 2).Return(nil, errItemNotFound)
+			},
+			wants: wants{
+				code: http.StatusNotFound,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("POST", "/items/"+tt.id+"/restore", nil)
+			req.SetPathValue("id", tt.id)
+			rr := httptest.NewRecorder()
+			h.RestoreItem(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+		})
+	}
+}
+
+func TestDeleteItem(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		id       string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: correctly deleted": {
+			id: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Delete(gomock.Any(), 1).Return(nil)
+			},
+			wants: wants{
+				code: http.StatusNoContent,
+			},
+		},
+		"ng: item not found": {
+			id: "2",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Delete(gomock.Any(), 2).Return(errItemNotFound)
+			},
+			wants: wants{
+				code: http.StatusNotFound,
+			},
+		},
+		"ng: invalid id": {
+			id:       "abc",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("DELETE", "/items/"+tt.id, nil)
+			req.SetPathValue("id", tt.id)
+			rr := httptest.NewRecorder()
+			h.DeleteItem(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+		})
+	}
+}
+
+func TestDeleteItemSharedImage(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewMemoryItemRepository()
+	h := &Handlers{imgDirPath: t.TempDir(), itemRepo: repo}
+
+	// the same image is stored once under its hash, so both items refer to one file
+	fileName, err := storeImageBytes(h, newTestImage(t))
+	if err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	var ids []int
+	for _, name := range []string{"jacket", "coat"} {
+		id, err := repo.Insert(ctx, &Item{Name: name, Category: "fashion", ImageName: fileName})
+		if err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	for i, id := range ids {
+		req := httptest.NewRequest("DELETE", "/items/"+strconv.Itoa(id), nil)
+		req.SetPathValue("id", strconv.Itoa(id))
+		rr := httptest.NewRecorder()
+		h.DeleteItem(rr, req)
+
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+
+		// deleted items can be restored, so they still count as references to the image
+		result, err := CollectOrphanedImages(ctx, h.imgDirPath, defaultImageName, repo, time.Now().Add(2*gcImagesMinAge), false)
+		if err != nil {
+			t.Fatalf("failed to collect orphaned images: %v", err)
+		}
+		if len(result.Removed) != 0 {
+			t.Errorf("expected no images removed after deleting %d items, got %v", i+1, result.Removed)
+		}
+		if _, err := os.Stat(filepath.Join(h.imgDirPath, fileName)); err != nil {
+			t.Fatalf("expected the shared image to be kept after deleting %d items: %v", i+1, err)
+		}
+	}
+}
+
+func TestStoreImage(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		ext         string
+		contentType string
+		err         error
+	}
+	cases := map[string]struct {
+		image []byte
+		wants
+	}{
+		"ok: jpeg": {
+			image: newTestImage(t),
+			wants: wants{
+				ext:         ".jpg",
+				contentType: "image/jpeg",
+			},
+		},
+		"ok: png": {
+			image: newTestPNG(t),
+			wants: wants{
+				ext:         ".png",
+				contentType: "image/png",
+			},
+		},
+		"ok: webp": {
+			// only the RIFF/WEBP header is needed to detect the format
+			image: []byte("RIFF\x00\x00\x00\x00WEBPVP8 "),
+			wants: wants{
+				ext:         ".webp",
+				contentType: "image/webp",
+			},
+		},
+		"ng: not an image": {
+			image: []byte("this is not an image"),
+			wants: wants{
+				err: errUnsupportedImageType,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := &Handlers{imgDirPath: t.TempDir()}
+
+			fileName, err := storeImageBytes(h, tt.image)
+			if tt.wants.err != nil {
+				if !errors.Is(err, tt.wants.err) {
+					t.Errorf("expected error %v, got %v", tt.wants.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to store image: %v", err)
+			}
+			if filepath.Ext(fileName) != tt.wants.ext {
+				t.Errorf("expected extension %s, got %s", tt.wants.ext, fileName)
+			}
+			// the temporary file has been renamed to the hash name
+			entries, err := os.ReadDir(h.imgDirPath)
+			if err != nil {
+				t.Fatalf("failed to read image directory: %v", err)
+			}
+			if len(entries) != 1 || entries[0].Name() != fileName {
+				t.Errorf("expected only %s in the image directory, got %v", fileName, entries)
+			}
+
+			// the stored image is served with the matching content type
+			req := httptest.NewRequest("GET", "/images/"+fileName, nil)
+			req.SetPathValue("filename", fileName)
+			rr := httptest.NewRecorder()
+			h.GetImage(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+			}
+			if got := rr.Header().Get("Content-Type"); got != tt.wants.contentType {
+				t.Errorf("expected Content-Type %s, got %s", tt.wants.contentType, got)
+			}
+		})
+	}
+}
+
+func TestStoreImageFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "image.jpg")
+
+	// storing again replaces the whole file
+	for _, image := range [][]byte{[]byte("first image"), []byte("second")} {
+		if err := StoreImage(fileName, image); err != nil {
+			t.Fatalf("failed to store image: %v", err)
+		}
+		got, err := os.ReadFile(fileName)
+		if err != nil {
+			t.Fatalf("failed to read stored image: %v", err)
+		}
+		if !bytes.Equal(got, image) {
+			t.Errorf("expected %q, got %q", image, got)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read image directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected no temporary files left, got %v", entries)
+	}
+
+	if err := StoreImage(filepath.Join(dir, "missing", "image.jpg"), []byte("image")); err == nil {
+		t.Errorf("expected an error for a missing directory")
+	}
+}
+
+func TestStoreImageStripsEXIF(t *testing.T) {
+	t.Parallel()
+
+	img := withEXIF(t, newTestImageOfSize(t, 10, 10), 1)
+	if !bytes.Contains(img, []byte("Exif\x00\x00")) {
+		t.Fatalf("test image has no EXIF")
 	}
 
-	// STEP 6-1: define test cases
+	h := &Handlers{imgDirPath: t.TempDir()}
+	fileName, err := storeImageBytes(h, img)
+	if err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	stored, err := os.ReadFile(filepath.Join(h.imgDirPath, fileName))
+	if err != nil {
+		t.Fatalf("failed to read stored image: %v", err)
+	}
+	if bytes.Contains(stored, []byte("Exif\x00\x00")) {
+		t.Errorf("expected EXIF to be stripped from the stored image")
+	}
+	// the file name is the hash of the cleaned image
+	hash := sha256.Sum256(stored)
+	if want := hex.EncodeToString(hash[:]) + ".jpg"; fileName != want {
+		t.Errorf("expected file name %s, got %s", want, fileName)
+	}
+}
+
+func TestStoreImageAutoOrients(t *testing.T) {
+	t.Parallel()
+
+	// a 40x20 image with a red block at the top left
+	src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := range 10 {
+		for x := range 10 {
+			src.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, src, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	type wants struct {
+		width, height int
+		redX, redY    int // a pixel expected to be in the red block
+	}
 	cases := map[string]struct {
-		args map[string]string
+		image []byte
 		wants
 	}{
-		"ok: valid request": {
-			args: map[string]string{
-				"name":     "", // fill here
-				"category": "", // fill here
-			},
-			wants: wants{
-				req: &AddItemRequest{
-					Name: "", // fill here
-					// Category: "", // fill here
-				},
-				err: false,
-			},
+		"ok: no EXIF": {
+			image: buf.Bytes(),
+			wants: wants{width: 40, height: 20, redX: 5, redY: 5},
 		},
-		"ng: empty request": {
-			args: map[string]string{},
-			wants: wants{
-				req: nil,
-				err: true,
-			},
+		"ok: upright": {
+			image: withEXIF(t, buf.Bytes(), 1),
+			wants: wants{width: 40, height: 20, redX: 5, redY: 5},
+		},
+		"ok: rotated 180": {
+			image: withEXIF(t, buf.Bytes(), 3),
+			wants: wants{width: 40, height: 20, redX: 35, redY: 15},
+		},
+		"ok: rotated 90 clockwise": {
+			image: withEXIF(t, buf.Bytes(), 6),
+			wants: wants{width: 20, height: 40, redX: 15, redY: 5},
+		},
+		"ok: rotated 90 counterclockwise": {
+			image: withEXIF(t, buf.Bytes(), 8),
+			wants: wants{width: 20, height: 40, redX: 5, redY: 35},
 		},
 	}
 
@@ -50,97 +4009,420 @@ func TestParseAddItemRequest(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 
-			// prepare request body
-			values := url.Values{}
-			for k, v := range tt.args {
-				values.Set(k, v)
+			h := &Handlers{imgDirPath: t.TempDir()}
+			fileName, err := storeImageBytes(h, tt.image)
+			if err != nil {
+				t.Fatalf("failed to store image: %v", err)
 			}
 
-			// prepare HTTP request
-			req, err := http.NewRequest("POST", "http://localhost:9000/items", strings.NewReader(values.Encode()))
+			f, err := os.Open(filepath.Join(h.imgDirPath, fileName))
 			if err != nil {
-				t.Fatalf("failed to create request: %v", err)
+				t.Fatalf("failed to open stored image: %v", err)
 			}
-			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			defer f.Close()
 
-			// execute test target
-			got, err := parseAddItemRequest(req)
+			img, err := jpeg.Decode(f)
+			if err != nil {
+				t.Fatalf("failed to decode stored image: %v", err)
+			}
+			if b := img.Bounds(); b.Dx() != tt.wants.width || b.Dy() != tt.wants.height {
+				t.Errorf("expected %dx%d image, got %dx%d", tt.wants.width, tt.wants.height, b.Dx(), b.Dy())
+			}
+			// JPEG is lossy, so only check that the pixel is mostly red
+			r, g, _, _ := img.At(tt.wants.redX, tt.wants.redY).RGBA()
+			if r < 0xC000 || g > 0x4000 {
+				t.Errorf("expected a red pixel at (%d, %d), got %v", tt.wants.redX, tt.wants.redY, img.At(tt.wants.redX, tt.wants.redY))
+			}
+		})
+	}
+}
 
-			// confirm the result
+func TestStoreImageConcurrentUploads(t *testing.T) {
+	t.Parallel()
+
+	h := &Handlers{imgDirPath: t.TempDir()}
+	img := newTestImageOfSize(t, 200, 100)
+
+	const n = 20
+	fileNames := make([]string, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fileNames[i], errs[i] = storeImageBytes(h, img)
+		}()
+	}
+	wg.Wait()
+
+	for i := range n {
+		if errs[i] != nil {
+			t.Fatalf("failed to store image: %v", errs[i])
+		}
+		if fileNames[i] != fileNames[0] {
+			t.Errorf("expected file name %s, got %s", fileNames[0], fileNames[i])
+		}
+	}
+
+	// only the image itself is left, without temporary files
+	entries, err := os.ReadDir(h.imgDirPath)
+	if err != nil {
+		t.Fatalf("failed to read image directory: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != fileNames[0] {
+		t.Errorf("expected only %s in the image directory, got %v", fileNames[0], entries)
+	}
+}
+
+func TestStoreImageResizesLargeImages(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		maxImageSize int
+		image        []byte
+		wantWidth    int
+		wantHeight   int
+	}{
+		"ok: default limit": {
+			image:      newTestImageOfSize(t, 2048, 1024),
+			wantWidth:  1024,
+			wantHeight: 512,
+		},
+		"ok: configured limit": {
+			maxImageSize: 100,
+			image:        newTestImageOfSize(t, 50, 400),
+			wantWidth:    12,
+			wantHeight:   100,
+		},
+		"ok: small image is kept": {
+			image:      newTestImageOfSize(t, 300, 200),
+			wantWidth:  300,
+			wantHeight: 200,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := &Handlers{imgDirPath: t.TempDir(), maxImageSize: tt.maxImageSize}
+			fileName, err := storeImageBytes(h, tt.image)
 			if err != nil {
-				if !tt.err {
-					t.Errorf("unexpected error: %v", err)
-				}
-				return
+				t.Fatalf("failed to store image: %v", err)
 			}
-			if diff := cmp.Diff(tt.wants.req, got); diff != "" {
-				t.Errorf("unexpected request (-want +got):\n%s", diff)
+
+			f, err := os.Open(filepath.Join(h.imgDirPath, fileName))
+			if err != nil {
+				t.Fatalf("failed to open stored image: %v", err)
+			}
+			defer f.Close()
+
+			cfg, err := jpeg.DecodeConfig(f)
+			if err != nil {
+				t.Fatalf("failed to decode stored image: %v", err)
+			}
+			if cfg.Width != tt.wantWidth || cfg.Height != tt.wantHeight {
+				t.Errorf("expected %dx%d image, got %dx%d", tt.wantWidth, tt.wantHeight, cfg.Width, cfg.Height)
 			}
 		})
 	}
 }
 
-func TestHelloHandler(t *testing.T) {
+func TestGetImageCaching(t *testing.T) {
 	t.Parallel()
 
-	// Please comment out for STEP 6-2
-	// predefine what we want
-	// type wants struct {
-	// 	code int               // desired HTTP status code
-	// 	body map[string]string // desired body
-	// }
-	// want := wants{
-	// 	code: http.StatusOK,
-	// 	body: map[string]string{"message": "Hello, world!"},
-	// }
+	h := &Handlers{imgDirPath: t.TempDir()}
+	fileName, err := storeImageBytes(h, newTestImage(t))
+	if err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	wantETag := fmt.Sprintf("%q", strings.TrimSuffix(fileName, filepath.Ext(fileName)))
 
-	// set up test
-	req := httptest.NewRequest("GET", "/hello", nil)
-	res := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/images/"+fileName, nil)
+	req.SetPathValue("filename", fileName)
+	rr := httptest.NewRecorder()
+	h.GetImage(rr, req)
 
-	h := &Handlers{}
-	h.Hello(res, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	if got := rr.Header().Get("Cache-Control"); got != "public, max-age=31536000, immutable" {
+		t.Errorf("unexpected Cache-Control: %s", got)
+	}
+	if got := rr.Header().Get("ETag"); got != wantETag {
+		t.Errorf("expected ETag %s, got %s", wantETag, got)
+	}
 
-	// STEP 6-2: confirm the status code
+	// a conditional request with the same ETag is answered without a body
+	req = httptest.NewRequest("GET", "/images/"+fileName, nil)
+	req.SetPathValue("filename", fileName)
+	req.Header.Set("If-None-Match", wantETag)
+	rr = httptest.NewRecorder()
+	h.GetImage(rr, req)
 
-	// STEP 6-2: confirm response body
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("expected status code %d, got %d", http.StatusNotModified, rr.Code)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("expected an empty body, got %d bytes", rr.Body.Len())
+	}
 }
 
-func TestAddItem(t *testing.T) {
+func TestGetImageDefault(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	placeholder := newTestPNG(t)
+	if err := os.WriteFile(filepath.Join(dir, "placeholder.png"), placeholder, 0644); err != nil {
+		t.Fatalf("failed to write default image: %v", err)
+	}
+	h := &Handlers{imgDirPath: dir, defaultImage: "placeholder.png"}
+
+	fileName := "0123456789abcdef.jpg"
+	req := httptest.NewRequest("GET", "/images/"+fileName, nil)
+	req.SetPathValue("filename", fileName)
+	rr := httptest.NewRecorder()
+	h.GetImage(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	if !bytes.Equal(rr.Body.Bytes(), placeholder) {
+		t.Errorf("expected the configured default image")
+	}
+	if got := rr.Header().Get("Content-Type"); got != "image/png" {
+		t.Errorf("expected Content-Type image/png, got %s", got)
+	}
+	// the real image may be stored later, so the default image must not be cached
+	if got := rr.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("expected Cache-Control no-store, got %s", got)
+	}
+	if got := rr.Header().Get("ETag"); got != "" {
+		t.Errorf("expected no ETag, got %s", got)
+	}
+}
+
+func TestGetImageRange(t *testing.T) {
+	t.Parallel()
+
+	h := &Handlers{imgDirPath: t.TempDir()}
+	fileName, err := storeImageBytes(h, newTestImageOfSize(t, 400, 300))
+	if err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	imgPath := filepath.Join(h.imgDirPath, fileName)
+
+	cases := map[string]struct {
+		url     string
+		path    string
+		handler func(w http.ResponseWriter, r *http.Request)
+	}{
+		"ok: image": {
+			url:     "/images/" + fileName,
+			path:    imgPath,
+			handler: h.GetImage,
+		},
+		"ok: thumbnail": {
+			url:     "/images/" + fileName + "/thumb",
+			path:    thumbnailPath(imgPath, ".jpg"),
+			handler: h.GetThumbnail,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			req.SetPathValue("filename", fileName)
+			req.Header.Set("Range", "bytes=0-99")
+			rr := httptest.NewRecorder()
+			tt.handler(rr, req)
+
+			if rr.Code != http.StatusPartialContent {
+				t.Fatalf("expected status code %d, got %d", http.StatusPartialContent, rr.Code)
+			}
+
+			data, err := os.ReadFile(tt.path)
+			if err != nil {
+				t.Fatalf("failed to read served file: %v", err)
+			}
+			if want := fmt.Sprintf("bytes 0-99/%d", len(data)); rr.Header().Get("Content-Range") != want {
+				t.Errorf("expected Content-Range %s, got %s", want, rr.Header().Get("Content-Range"))
+			}
+			if !bytes.Equal(rr.Body.Bytes(), data[:100]) {
+				t.Errorf("expected the first 100 bytes of the file, got %d bytes", rr.Body.Len())
+			}
+		})
+	}
+}
+
+func TestGetThumbnail(t *testing.T) {
 	t.Parallel()
 
+	defaultImage, err := os.ReadFile(filepath.Join("..", "images", "default.jpg"))
+	if err != nil {
+		t.Fatalf("failed to read default image: %v", err)
+	}
+
 	type wants struct {
-		code int
+		width  int
+		height int
 	}
 	cases := map[string]struct {
-		args     map[string]string
-		injector func(m *MockItemRepository)
+		image []byte
 		wants
 	}{
-		"ok: correctly inserted": {
-			args: map[string]string{
-				"name":     "used iPhone 16e",
-				"category": "phone",
+		"ok: large image is downscaled": {
+			image: newTestImageOfSize(t, 400, 300),
+			wants: wants{
+				width:  200,
+				height: 150,
 			},
-			injector: func(m *MockItemRepository) {
-				// STEP 6-3: define mock expectation
-				// succeeded to insert
+		},
+		"ok: small image keeps its size": {
+			image: newTestImageOfSize(t, 100, 50),
+			wants: wants{
+				width:  100,
+				height: 50,
 			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := &Handlers{imgDirPath: t.TempDir()}
+			fileName, err := storeImageBytes(h, tt.image)
+			if err != nil {
+				t.Fatalf("failed to store image: %v", err)
+			}
+
+			// request twice to check the cached thumbnail is served as well
+			for range 2 {
+				req := httptest.NewRequest("GET", "/images/"+fileName+"/thumb", nil)
+				req.SetPathValue("filename", fileName)
+				rr := httptest.NewRecorder()
+				h.GetThumbnail(rr, req)
+
+				if rr.Code != http.StatusOK {
+					t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+				}
+				cfg, err := jpeg.DecodeConfig(rr.Body)
+				if err != nil {
+					t.Fatalf("failed to decode thumbnail: %v", err)
+				}
+				if cfg.Width != tt.wants.width || cfg.Height != tt.wants.height {
+					t.Errorf("expected %dx%d thumbnail, got %dx%d", tt.wants.width, tt.wants.height, cfg.Width, cfg.Height)
+				}
+			}
+		})
+	}
+
+	t.Run("ok: missing image falls back to the default image", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "default.jpg"), defaultImage, 0644); err != nil {
+			t.Fatalf("failed to write default image: %v", err)
+		}
+		h := &Handlers{imgDirPath: dir}
+
+		req := httptest.NewRequest("GET", "/images/missing.jpg/thumb", nil)
+		req.SetPathValue("filename", "missing.jpg")
+		rr := httptest.NewRecorder()
+		h.GetThumbnail(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "default.thumb.jpg")); err != nil {
+			t.Errorf("expected the default thumbnail to be cached: %v", err)
+		}
+	})
+
+	t.Run("ng: thumbnail of a thumbnail", func(t *testing.T) {
+		t.Parallel()
+
+		h := &Handlers{imgDirPath: t.TempDir()}
+		fileName, err := storeImageBytes(h, newTestImageOfSize(t, 400, 300))
+		if err != nil {
+			t.Fatalf("failed to store image: %v", err)
+		}
+		thumbName := filepath.Base(thumbnailPath(fileName, ".jpg"))
+
+		req := httptest.NewRequest("GET", "/images/"+fileName+"/thumb", nil)
+		req.SetPathValue("filename", fileName)
+		h.GetThumbnail(httptest.NewRecorder(), req)
+
+		req = httptest.NewRequest("GET", "/images/"+thumbName+"/thumb", nil)
+		req.SetPathValue("filename", thumbName)
+		rr := httptest.NewRecorder()
+		h.GetThumbnail(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+		if _, err := os.Stat(filepath.Join(h.imgDirPath, thumbnailPath(thumbName, ".jpg"))); !os.IsNotExist(err) {
+			t.Errorf("expected no thumbnail of the thumbnail, got %v", err)
+		}
+	})
+}
+
+func TestGetThumbnailWebP(t *testing.T) {
+	t.Parallel()
+
+	// a noisy photo-like image, whose lossless WebP thumbnail is larger than the JPEG one
+	noisy := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	rnd := rand.New(rand.NewPCG(1, 2))
+	for i := range noisy.Pix {
+		noisy.Pix[i] = uint8(rnd.IntN(256))
+	}
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, noisy, nil); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	type wants struct {
+		contentType string
+	}
+	cases := map[string]struct {
+		image  []byte
+		accept string
+		wants
+	}{
+		"ok: webp is returned when accepted": {
+			image:  newTestImageOfSize(t, 400, 300),
+			accept: "image/webp,image/*;q=0.8",
 			wants: wants{
-				code: http.StatusOK,
+				contentType: "image/webp",
 			},
 		},
-		"ng: failed to insert": {
-			args: map[string]string{
-				"name":     "used iPhone 16e",
-				"category": "phone",
+		"ok: jpeg is returned without accept": {
+			image: newTestImageOfSize(t, 400, 300),
+			wants: wants{
+				contentType: "image/jpeg",
 			},
-			injector: func(m *MockItemRepository) {
-				// STEP 6-3: define mock expectation
-				// failed to insert
+		},
+		"ok: jpeg is returned for image wildcard": {
+			image:  newTestImageOfSize(t, 400, 300),
+			accept: "image/*",
+			wants: wants{
+				contentType: "image/jpeg",
 			},
+		},
+		"ok: jpeg is returned when webp is refused": {
+			image:  newTestImageOfSize(t, 400, 300),
+			accept: "image/webp;q=0, image/*",
 			wants: wants{
-				code: http.StatusInternalServerError,
+				contentType: "image/jpeg",
+			},
+		},
+		"ok: jpeg is returned when it is smaller": {
+			image:  buf.Bytes(),
+			accept: "image/webp",
+			wants: wants{
+				contentType: "image/jpeg",
 			},
 		},
 	}
@@ -149,36 +4431,70 @@ func TestAddItem(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 
-			ctrl := gomock.NewController(t)
+			h := &Handlers{imgDirPath: t.TempDir()}
+			fileName, err := storeImageBytes(h, tt.image)
+			if err != nil {
+				t.Fatalf("failed to store image: %v", err)
+			}
 
-			mockIR := NewMockItemRepository(ctrl)
-			tt.injector(mockIR)
-			h := &Handlers{itemRepo: mockIR}
+			req := httptest.NewRequest("GET", "/images/"+fileName+"/thumb", nil)
+			req.SetPathValue("filename", fileName)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rr := httptest.NewRecorder()
+			h.GetThumbnail(rr, req)
 
-			values := url.Values{}
-			for k, v := range tt.args {
-				values.Set(k, v)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+			}
+			if got := rr.Header().Get("Content-Type"); got != tt.wants.contentType {
+				t.Errorf("expected Content-Type %q, got %q", tt.wants.contentType, got)
+			}
+			if got := rr.Header().Get("Vary"); got != "Accept" {
+				t.Errorf("expected Vary Accept, got %q", got)
+			}
+			cfg, _, err := image.DecodeConfig(rr.Body)
+			if err != nil {
+				t.Fatalf("failed to decode thumbnail: %v", err)
+			}
+			if cfg.Width != 200 || cfg.Height != 150 {
+				t.Errorf("expected 200x150 thumbnail, got %dx%d", cfg.Width, cfg.Height)
 			}
-			req := httptest.NewRequest("POST", "/items", strings.NewReader(values.Encode()))
-			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		})
+	}
 
+	t.Run("ok: each format is cached separately", func(t *testing.T) {
+		t.Parallel()
+
+		h := &Handlers{imgDirPath: t.TempDir()}
+		fileName, err := storeImageBytes(h, newTestImageOfSize(t, 400, 300))
+		if err != nil {
+			t.Fatalf("failed to store image: %v", err)
+		}
+
+		for _, accept := range []string{"image/webp", "image/jpeg", "image/webp"} {
+			req := httptest.NewRequest("GET", "/images/"+fileName+"/thumb", nil)
+			req.SetPathValue("filename", fileName)
+			req.Header.Set("Accept", accept)
 			rr := httptest.NewRecorder()
-			h.AddItem(rr, req)
+			h.GetThumbnail(rr, req)
 
-			if tt.wants.code != rr.Code {
-				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
 			}
-			if tt.wants.code >= 400 {
-				return
+			if got, want := rr.Header().Get("Content-Type"), accept; got != want {
+				t.Errorf("expected Content-Type %q, got %q", want, got)
 			}
+		}
 
-			for _, v := range tt.args {
-				if !strings.Contains(rr.Body.String(), v) {
-					t.Errorf("response body does not contain %s, got: %s", v, rr.Body.String())
-				}
+		imgPath := filepath.Join(h.imgDirPath, fileName)
+		for _, ext := range []string{".jpg", ".webp"} {
+			if _, err := os.Stat(thumbnailPath(imgPath, ext)); err != nil {
+				t.Errorf("expected the %s thumbnail to be cached: %v", ext, err)
 			}
-		})
-	}
+		}
+	})
 }
 
 // STEP 6-4: uncomment this test
@@ -299,3 +4615,87 @@ func TestAddItem(t *testing.T) {
 
 // 	return db, closers, nil
 // }
+
+func BenchmarkStoreImage(b *testing.B) {
+	src := image.NewRGBA(image.Rect(0, 0, 1024, 768))
+	for i := range src.Pix {
+		src.Pix[i] = byte(i)
+	}
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, src, nil); err != nil {
+		b.Fatalf("failed to encode test image: %v", err)
+	}
+
+	// both cases read the same multipart request, so that the numbers include reading the upload
+	req := newMultipartRequest(b, "POST", "/items", map[string]string{"name": "jacket", "category": "fashion", "price": "3000"}, buf.Bytes())
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		b.Fatalf("failed to read request body: %v", err)
+	}
+	contentType := req.Header.Get("Content-Type")
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("POST", "/items", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		return req
+	}
+
+	// buffered is how images were stored before: the upload is read into memory, decoded to validate it,
+	// and the cleaned image is built in memory, hashed and then written.
+	b.Run("buffered", func(b *testing.B) {
+		dir := b.TempDir()
+		b.ReportAllocs()
+		for b.Loop() {
+			req := newRequest()
+			if err := req.ParseMultipartForm(defaultMultipartMemory); err != nil {
+				b.Fatalf("failed to parse form: %v", err)
+			}
+			f, err := req.MultipartForm.File["image"][0].Open()
+			if err != nil {
+				b.Fatalf("failed to open image: %v", err)
+			}
+			img, err := io.ReadAll(io.LimitReader(f, defaultMaxUploadSize+1))
+			f.Close()
+			if err != nil {
+				b.Fatalf("failed to read image: %v", err)
+			}
+			if _, _, err := image.Decode(bytes.NewReader(img)); err != nil {
+				b.Fatalf("failed to decode image: %v", err)
+			}
+			cleaned := &bytes.Buffer{}
+			if err := writeCleanImage(cleaned, bytes.NewReader(img), defaultMaxImageSize); err != nil {
+				b.Fatalf("failed to clean image: %v", err)
+			}
+			hash := sha256.Sum256(cleaned.Bytes())
+			filePath := filepath.Join(dir, hex.EncodeToString(hash[:])+".jpg")
+			if err := StoreImage(filePath, cleaned.Bytes()); err != nil {
+				b.Fatalf("failed to store image: %v", err)
+			}
+
+			b.StopTimer()
+			req.MultipartForm.RemoveAll()
+			os.Remove(filePath)
+			b.StartTimer()
+		}
+	})
+
+	// streamed spools the upload to a temporary file while hashing it, and decodes and cleans it from there.
+	b.Run("streamed", func(b *testing.B) {
+		h := &Handlers{imgDirPath: b.TempDir()}
+		b.ReportAllocs()
+		for b.Loop() {
+			parsed, err := parseAddItemRequest(newRequest(), h.imgDirPath, defaultMaxUploadSize, defaultMultipartMemory)
+			if err != nil {
+				b.Fatalf("failed to parse request: %v", err)
+			}
+			fileName, err := h.storeImage(parsed.Image)
+			if err != nil {
+				b.Fatalf("failed to store image: %v", err)
+			}
+
+			b.StopTimer()
+			parsed.removeImages()
+			os.Remove(filepath.Join(h.imgDirPath, fileName))
+			b.StartTimer()
+		}
+	})
+}
@@ -13,6 +13,16 @@ const (
 func main() {
 	// This is the entry point of the application.
 	// You don't need to modify this function.
+
+	// go run ./cmd/api migrate items.json imports a legacy items.json
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(app.Migrate(os.Args[2:]))
+	}
+	// go run ./cmd/api gc-images [--dry-run] removes images which no item refers to
+	if len(os.Args) > 1 && os.Args[1] == "gc-images" {
+		os.Exit(app.GCImages(imageDirPath, os.Args[2:]))
+	}
+
 	os.Exit(app.Server{
 		Port:         port,
 		ImageDirPath: imageDirPath,